@@ -0,0 +1,233 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+const (
+	// DefaultRequeueInterval is how often bindings are reconciled again
+	DefaultRequeueInterval = 5 * time.Minute
+	// DefaultDropletTagPollInterval is how often bindings targeting a DropletTag are reconciled
+	DefaultDropletTagPollInterval = time.Minute
+	// DefaultRetryBaseDelay is how long to wait before retrying after a transient error
+	DefaultRetryBaseDelay = 5 * time.Second
+	// DefaultMaxRetryDelay caps the delay after repeated transient errors
+	DefaultMaxRetryDelay = 5 * time.Minute
+	// DefaultMaxStatusCandidates is the number of nodes reported in status.candidates
+	DefaultMaxStatusCandidates = 10
+)
+
+// DigitalOceanConfig configures access to the DigitalOcean API
+type DigitalOceanConfig struct {
+	// APIURL is the base URL of the DigitalOcean API, e.g. a fake API for local
+	// development. Defaults to the DigitalOcean API.
+	// +optional
+	APIURL string `json:"apiURL,omitempty"`
+
+	// QPS limits the requests per second made with each token. 0 disables the limit.
+	// +optional
+	QPS float32 `json:"qps,omitempty"`
+
+	// Burst is the number of requests which may exceed QPS at once. Defaults to 1
+	// when QPS is set.
+	// +optional
+	Burst int `json:"burst,omitempty"`
+}
+
+// FloatingIPBindingConfig configures the FloatingIPBinding controller
+type FloatingIPBindingConfig struct {
+	// RequeueInterval is how often bindings are reconciled again to correct drift.
+	// Defaults to 5m.
+	// +optional
+	RequeueInterval *metav1.Duration `json:"requeueInterval,omitempty"`
+
+	// DropletTagPollInterval is how often bindings targeting a DropletTag are
+	// reconciled, as droplets can't be watched. Defaults to 1m.
+	// +optional
+	DropletTagPollInterval *metav1.Duration `json:"dropletTagPollInterval,omitempty"`
+
+	// RetryBaseDelay is how long to wait before retrying after a transient error. The
+	// delay doubles with each consecutive error. Defaults to 5s.
+	// +optional
+	RetryBaseDelay *metav1.Duration `json:"retryBaseDelay,omitempty"`
+
+	// MaxRetryDelay caps the delay after repeated transient errors. Defaults to 5m.
+	// +optional
+	MaxRetryDelay *metav1.Duration `json:"maxRetryDelay,omitempty"`
+
+	// MaxConcurrentReconciles is how many bindings are reconciled at once. Defaults to 1.
+	// +optional
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// MaxStatusCandidates limits the number of nodes reported in status.candidates.
+	// Defaults to 10.
+	// +optional
+	MaxStatusCandidates int `json:"maxStatusCandidates,omitempty"`
+
+	// DryRun records the planned droplet of every binding without assigning floating IPs
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Freeze stops floating IPs which are already assigned from being moved or
+	// unassigned, e.g. during maintenance. Unassigned floating IPs are still assigned.
+	// +optional
+	Freeze bool `json:"freeze,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FloatingIPControllerConfig is the Schema for the controller's --config file
+type FloatingIPControllerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// ControllerName selects the bindings managed by this instance by their
+	// spec.controllerName. Defaults to digitalocean.smirlwebs.com/floating-ip-controller
+	// +optional
+	ControllerName string `json:"controllerName,omitempty"`
+
+	// WatchNamespaces restricts the instance to bindings in these namespaces.
+	// Defaults to all namespaces.
+	// +optional
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// LeaseNamespace holds the Leases which advertise each running instance, so that
+	// bindings no instance claims can be reported. Every instance in the cluster must use
+	// the same LeaseNamespace to see the others. Defaults to the controller's namespace.
+	// +optional
+	LeaseNamespace string `json:"leaseNamespace,omitempty"`
+
+	// DigitalOcean configures access to the DigitalOcean API
+	// +optional
+	DigitalOcean DigitalOceanConfig `json:"digitalOcean,omitempty"`
+
+	// FloatingIPBinding configures the FloatingIPBinding controller
+	// +optional
+	FloatingIPBinding FloatingIPBindingConfig `json:"floatingIPBinding,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FloatingIPControllerConfig{})
+}
+
+// Default sets the default value of every unset field
+func (c *FloatingIPControllerConfig) Default() {
+	if c.ControllerName == "" {
+		c.ControllerName = digitaloceanv1beta1.DefaultControllerName
+	}
+	b := &c.FloatingIPBinding
+	if b.RequeueInterval == nil {
+		b.RequeueInterval = &metav1.Duration{Duration: DefaultRequeueInterval}
+	}
+	if b.DropletTagPollInterval == nil {
+		b.DropletTagPollInterval = &metav1.Duration{Duration: DefaultDropletTagPollInterval}
+	}
+	if b.RetryBaseDelay == nil {
+		b.RetryBaseDelay = &metav1.Duration{Duration: DefaultRetryBaseDelay}
+	}
+	if b.MaxRetryDelay == nil {
+		b.MaxRetryDelay = &metav1.Duration{Duration: DefaultMaxRetryDelay}
+	}
+	if b.MaxConcurrentReconciles == 0 {
+		b.MaxConcurrentReconciles = 1
+	}
+	if b.MaxStatusCandidates == 0 {
+		b.MaxStatusCandidates = DefaultMaxStatusCandidates
+	}
+	if c.DigitalOcean.QPS > 0 && c.DigitalOcean.Burst == 0 {
+		c.DigitalOcean.Burst = 1
+	}
+}
+
+// Validate returns an error describing every invalid field
+func (c *FloatingIPControllerConfig) Validate() error {
+	var errs field.ErrorList
+
+	if len(c.ControllerName) > 253 {
+		errs = append(errs, field.TooLong(field.NewPath("controllerName"), c.ControllerName, 253))
+	}
+	for i, namespace := range c.WatchNamespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			errs = append(errs, field.Invalid(field.NewPath("watchNamespaces").Index(i), namespace, msg))
+		}
+	}
+	if c.LeaseNamespace != "" {
+		for _, msg := range validation.IsDNS1123Label(c.LeaseNamespace) {
+			errs = append(errs, field.Invalid(field.NewPath("leaseNamespace"), c.LeaseNamespace, msg))
+		}
+	}
+
+	doPath := field.NewPath("digitalOcean")
+	if c.DigitalOcean.APIURL != "" {
+		u, err := url.Parse(c.DigitalOcean.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, field.Invalid(doPath.Child("apiURL"), c.DigitalOcean.APIURL,
+				"must be an absolute http or https URL"))
+		}
+	}
+	if c.DigitalOcean.QPS < 0 {
+		errs = append(errs, field.Invalid(doPath.Child("qps"), c.DigitalOcean.QPS, "must not be negative"))
+	}
+	if c.DigitalOcean.Burst < 0 {
+		errs = append(errs, field.Invalid(doPath.Child("burst"), c.DigitalOcean.Burst, "must not be negative"))
+	}
+
+	bindingPath := field.NewPath("floatingIPBinding")
+	b := c.FloatingIPBinding
+	if b.RequeueInterval != nil && b.RequeueInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(bindingPath.Child("requeueInterval"), b.RequeueInterval.Duration.String(),
+			"must be positive"))
+	}
+	if b.DropletTagPollInterval != nil && b.DropletTagPollInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(bindingPath.Child("dropletTagPollInterval"),
+			b.DropletTagPollInterval.Duration.String(), "must be positive"))
+	}
+	if b.RetryBaseDelay != nil && b.RetryBaseDelay.Duration <= 0 {
+		errs = append(errs, field.Invalid(bindingPath.Child("retryBaseDelay"), b.RetryBaseDelay.Duration.String(),
+			"must be positive"))
+	}
+	if b.MaxRetryDelay != nil && b.RetryBaseDelay != nil && b.MaxRetryDelay.Duration < b.RetryBaseDelay.Duration {
+		errs = append(errs, field.Invalid(bindingPath.Child("maxRetryDelay"), b.MaxRetryDelay.Duration.String(),
+			"must not be less than retryBaseDelay"))
+	}
+	if b.MaxConcurrentReconciles < 0 {
+		errs = append(errs, field.Invalid(bindingPath.Child("maxConcurrentReconciles"), b.MaxConcurrentReconciles,
+			"must not be negative"))
+	}
+	if b.MaxStatusCandidates < 0 {
+		errs = append(errs, field.Invalid(bindingPath.Child("maxStatusCandidates"), b.MaxStatusCandidates,
+			"must not be negative"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errs.ToAggregate())
+	}
+	return nil
+}
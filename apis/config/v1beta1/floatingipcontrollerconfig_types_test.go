@@ -0,0 +1,201 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+func duration(d time.Duration) *metav1.Duration {
+	return &metav1.Duration{Duration: d}
+}
+
+func TestDefault(t *testing.T) {
+	c := &FloatingIPControllerConfig{}
+	c.Default()
+	b := c.FloatingIPBinding
+	if c.ControllerName != digitaloceanv1beta1.DefaultControllerName {
+		t.Errorf("controllerName = %s", c.ControllerName)
+	}
+	if b.RequeueInterval.Duration != DefaultRequeueInterval ||
+		b.DropletTagPollInterval.Duration != DefaultDropletTagPollInterval ||
+		b.RetryBaseDelay.Duration != DefaultRetryBaseDelay ||
+		b.MaxRetryDelay.Duration != DefaultMaxRetryDelay {
+		t.Errorf("floatingIPBinding durations = %+v", b)
+	}
+	if b.MaxConcurrentReconciles != 1 || b.MaxStatusCandidates != DefaultMaxStatusCandidates {
+		t.Errorf("floatingIPBinding = %+v", b)
+	}
+	if c.DigitalOcean.Burst != 0 {
+		t.Errorf("digitalOcean.burst = %d without a qps, want 0", c.DigitalOcean.Burst)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() of the defaults error = %v", err)
+	}
+
+	t.Run("keeps set values", func(t *testing.T) {
+		c := &FloatingIPControllerConfig{
+			ControllerName: "example.com/other",
+			DigitalOcean:   DigitalOceanConfig{QPS: 2, Burst: 4},
+			FloatingIPBinding: FloatingIPBindingConfig{
+				RequeueInterval:         duration(time.Minute),
+				MaxConcurrentReconciles: 3,
+				MaxStatusCandidates:     2,
+			},
+		}
+		c.Default()
+		if c.ControllerName != "example.com/other" || c.DigitalOcean.Burst != 4 ||
+			c.FloatingIPBinding.RequeueInterval.Duration != time.Minute ||
+			c.FloatingIPBinding.MaxConcurrentReconciles != 3 || c.FloatingIPBinding.MaxStatusCandidates != 2 {
+			t.Errorf("Default() changed set values: %+v", c)
+		}
+	})
+
+	t.Run("burst defaults to 1 with a qps", func(t *testing.T) {
+		c := &FloatingIPControllerConfig{DigitalOcean: DigitalOceanConfig{QPS: 0.5}}
+		c.Default()
+		if c.DigitalOcean.Burst != 1 {
+			t.Errorf("digitalOcean.burst = %d, want 1", c.DigitalOcean.Burst)
+		}
+	})
+}
+
+func TestValidateReportsEveryField(t *testing.T) {
+	c := &FloatingIPControllerConfig{}
+	c.Default()
+	c.DigitalOcean.QPS = -1
+	c.FloatingIPBinding.MaxStatusCandidates = -1
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validate() error = nil")
+	}
+	for _, field := range []string{"digitalOcean.qps", "floatingIPBinding.maxStatusCandidates"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Validate() error = %v, want one for %s", err, field)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *FloatingIPControllerConfig)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *FloatingIPControllerConfig) {},
+		},
+		{
+			name:    "controller name too long",
+			modify:  func(c *FloatingIPControllerConfig) { c.ControllerName = strings.Repeat("a", 254) },
+			wantErr: "controllerName",
+		},
+		{
+			name:    "invalid watch namespace",
+			modify:  func(c *FloatingIPControllerConfig) { c.WatchNamespaces = []string{"default", "Not_Valid"} },
+			wantErr: "watchNamespaces[1]",
+		},
+		{
+			name:    "invalid lease namespace",
+			modify:  func(c *FloatingIPControllerConfig) { c.LeaseNamespace = "-leases" },
+			wantErr: "leaseNamespace",
+		},
+		{
+			name:    "relative API URL",
+			modify:  func(c *FloatingIPControllerConfig) { c.DigitalOcean.APIURL = "/v2" },
+			wantErr: "digitalOcean.apiURL",
+		},
+		{
+			name:    "API URL scheme",
+			modify:  func(c *FloatingIPControllerConfig) { c.DigitalOcean.APIURL = "ftp://api.example.com" },
+			wantErr: "digitalOcean.apiURL",
+		},
+		{
+			name:   "http API URL",
+			modify: func(c *FloatingIPControllerConfig) { c.DigitalOcean.APIURL = "http://localhost:8080" },
+		},
+		{
+			name:    "negative qps",
+			modify:  func(c *FloatingIPControllerConfig) { c.DigitalOcean.QPS = -1 },
+			wantErr: "digitalOcean.qps",
+		},
+		{
+			name:    "negative burst",
+			modify:  func(c *FloatingIPControllerConfig) { c.DigitalOcean.Burst = -1 },
+			wantErr: "digitalOcean.burst",
+		},
+		{
+			name:    "zero requeue interval",
+			modify:  func(c *FloatingIPControllerConfig) { c.FloatingIPBinding.RequeueInterval = duration(0) },
+			wantErr: "floatingIPBinding.requeueInterval",
+		},
+		{
+			name: "negative droplet tag poll interval",
+			modify: func(c *FloatingIPControllerConfig) {
+				c.FloatingIPBinding.DropletTagPollInterval = duration(-time.Second)
+			},
+			wantErr: "floatingIPBinding.dropletTagPollInterval",
+		},
+		{
+			name:    "zero retry base delay",
+			modify:  func(c *FloatingIPControllerConfig) { c.FloatingIPBinding.RetryBaseDelay = duration(0) },
+			wantErr: "floatingIPBinding.retryBaseDelay",
+		},
+		{
+			name: "max retry delay below base delay",
+			modify: func(c *FloatingIPControllerConfig) {
+				c.FloatingIPBinding.RetryBaseDelay = duration(time.Minute)
+				c.FloatingIPBinding.MaxRetryDelay = duration(time.Second)
+			},
+			wantErr: "floatingIPBinding.maxRetryDelay",
+		},
+		{
+			name:    "negative concurrent reconciles",
+			modify:  func(c *FloatingIPControllerConfig) { c.FloatingIPBinding.MaxConcurrentReconciles = -1 },
+			wantErr: "floatingIPBinding.maxConcurrentReconciles",
+		},
+		{
+			name:    "negative status candidates",
+			modify:  func(c *FloatingIPControllerConfig) { c.FloatingIPBinding.MaxStatusCandidates = -1 },
+			wantErr: "floatingIPBinding.maxStatusCandidates",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &FloatingIPControllerConfig{}
+			c.Default()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want one for %s", err, tt.wantErr)
+			}
+		})
+	}
+}
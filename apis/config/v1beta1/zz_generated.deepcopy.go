@@ -0,0 +1,109 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DigitalOceanConfig) DeepCopyInto(out *DigitalOceanConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DigitalOceanConfig.
+func (in *DigitalOceanConfig) DeepCopy() *DigitalOceanConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DigitalOceanConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FloatingIPBindingConfig) DeepCopyInto(out *FloatingIPBindingConfig) {
+	*out = *in
+	if in.RequeueInterval != nil {
+		in, out := &in.RequeueInterval, &out.RequeueInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.DropletTagPollInterval != nil {
+		in, out := &in.DropletTagPollInterval, &out.DropletTagPollInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RetryBaseDelay != nil {
+		in, out := &in.RetryBaseDelay, &out.RetryBaseDelay
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxRetryDelay != nil {
+		in, out := &in.MaxRetryDelay, &out.MaxRetryDelay
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FloatingIPBindingConfig.
+func (in *FloatingIPBindingConfig) DeepCopy() *FloatingIPBindingConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(FloatingIPBindingConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FloatingIPControllerConfig) DeepCopyInto(out *FloatingIPControllerConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+	if in.WatchNamespaces != nil {
+		in, out := &in.WatchNamespaces, &out.WatchNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.DigitalOcean = in.DigitalOcean
+	in.FloatingIPBinding.DeepCopyInto(&out.FloatingIPBinding)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FloatingIPControllerConfig.
+func (in *FloatingIPControllerConfig) DeepCopy() *FloatingIPControllerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(FloatingIPControllerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FloatingIPControllerConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
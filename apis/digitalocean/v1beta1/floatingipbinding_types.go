@@ -17,6 +17,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -28,11 +29,159 @@ const (
 	Random NodeSelectorPolicy = "Random"
 )
 
+// +kubebuilder:validation:Enum=Nodes;DropletTag
+type TargetMode string
+
+const (
+	// Nodes selects Kubernetes nodes using the NodeSelector
+	Nodes TargetMode = "Nodes"
+	// DropletTag selects droplets with the DropletTag, which don't need to be Kubernetes nodes
+	DropletTag TargetMode = "DropletTag"
+)
+
+// +kubebuilder:validation:Enum=Always;IfUnassigned;IfClusterNode
+type TakeoverPolicy string
+
+const (
+	// Always move the floating IP, whatever it is currently assigned to
+	Always TakeoverPolicy = "Always"
+	// IfUnassigned only assigns the floating IP when it is not assigned to any droplet
+	IfUnassigned TakeoverPolicy = "IfUnassigned"
+	// IfClusterNode only moves the floating IP when it is unassigned or assigned to a node in this
+	// cluster, or to a droplet with the DropletTag
+	IfClusterNode TakeoverPolicy = "IfClusterNode"
+)
+
+// +kubebuilder:validation:Enum=Keep;Unassign;FallbackSelector
+type WhenNoCandidatesPolicy string
+
+const (
+	// Keep the floating IP assigned to its current droplet
+	Keep WhenNoCandidatesPolicy = "Keep"
+	// Unassign the floating IP from its current droplet
+	Unassign WhenNoCandidatesPolicy = "Unassign"
+	// FallbackSelector selects a node using the FallbackNodeSelector instead
+	FallbackSelector WhenNoCandidatesPolicy = "FallbackSelector"
+)
+
+// ReassignAnnotation requests a manual failover when set to a new value, e.g. a timestamp.
+// The floating IP is moved to another eligible node and each value is only acted on once.
+const ReassignAnnotation string = "digitalocean.smirlwebs.com/reassign"
+
+// DefaultControllerName is the controllerName of bindings which don't set one, and of
+// controller instances started without --controller-name
+const DefaultControllerName string = "digitalocean.smirlwebs.com/floating-ip-controller"
+
+// NodeLabelPrefix prefixes the binding namespace and name in the label, annotation and taint
+// keys set on the node holding the floating IP,
+// e.g. floating-ip.digitalocean.smirlwebs.com/default.main=true.
+// The annotation holds the floating IP.
+const NodeLabelPrefix string = "floating-ip.digitalocean.smirlwebs.com/"
+
+const (
+	// ConditionAssigned is True when the floating IP is assigned to a selected droplet
+	ConditionAssigned string = "Assigned"
+	// ConditionConflict is True when the TakeoverPolicy forbids moving the floating IP
+	// from the droplet it is currently assigned to
+	ConditionConflict string = "Conflict"
+	// ConditionEgressReady is True when the node agent on the node holding the floating IP
+	// has routed the binding's egress traffic through the anchor gateway
+	ConditionEgressReady string = "EgressReady"
+	// ConditionDNSReady is True when the DNS record points at the floating IP
+	ConditionDNSReady string = "DNSReady"
+	// ConditionFirewallReady is True when the droplet holding the floating IP is in the
+	// Cloud Firewall
+	ConditionFirewallReady string = "FirewallReady"
+	// ConditionServiceReady is True when the floating IP is published in the status of
+	// the Service referenced by ServiceRef
+	ConditionServiceReady string = "ServiceReady"
+	// ConditionClaimed is False when no running controller instance has the binding's
+	// controllerName
+	ConditionClaimed string = "Claimed"
+	// ConditionStalled is True when a permanent error stopped the binding being reconciled.
+	// It is retried when the spec changes.
+	ConditionStalled string = "Stalled"
+)
+
+// +kubebuilder:validation:Enum=Retain;Release
+type DeletionPolicy string
+
+const (
+	// Retain leaves the floating IP, DNS record and firewall as they are when the binding is deleted
+	Retain DeletionPolicy = "Retain"
+	// Release unassigns the floating IP, deletes the DNS record and removes the droplet
+	// from the firewall when the binding is deleted
+	Release DeletionPolicy = "Release"
+)
+
+// Finalizer is added to bindings with the Release DeletionPolicy or a ServiceRef so the
+// floating IP can be released and removed from the Service before the binding is deleted
+const Finalizer string = "digitalocean.smirlwebs.com/finalizer"
+
+// LoadBalancerClass is the spec.loadBalancerClass of LoadBalancer Services the floating IP
+// may be published in. The status of other LoadBalancer Services belongs to their load
+// balancer controller.
+const LoadBalancerClass string = "digitalocean.smirlwebs.com/floating-ip"
+
+// DNS is an A record pointing at the floating IP
+type DNS struct {
+	// The DigitalOcean domain holding the record, e.g. example.com
+	Domain string `json:"domain"`
+	// The name of the record relative to the domain, e.g. www, or @ for the domain itself
+	Name string `json:"name"`
+	// The TTL of the record in seconds.
+	// Defaults to 1800
+	// +kubebuilder:default:=1800
+	// +kubebuilder:validation:Minimum=30
+	// +optional
+	TTL int `json:"ttl,omitempty"`
+}
+
+// ServiceReference names a Service in the binding's namespace
+type ServiceReference struct {
+	// The name of the Service
+	Name string `json:"name"`
+}
+
+// Egress selects traffic routed through the floating IP by the node agent
+type Egress struct {
+	// The source CIDRs, e.g. the pod CIDR, of traffic leaving the node holding the
+	// floating IP which is routed through the anchor gateway
+	// +kubebuilder:validation:MinItems=1
+	SourceCIDRs []string `json:"sourceCIDRs"`
+}
+
+// CredentialsReference refers to a key in a Secret holding a DigitalOcean API token
+type CredentialsReference struct {
+	// The name of the Secret in the same namespace as the FloatingIPBinding
+	Name string `json:"name"`
+
+	// The key in the Secret holding the token
+	// Defaults to DO_TOKEN
+	// +kubebuilder:default:="DO_TOKEN"
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
 // FloatingIPBindingSpec defines the desired state of FloatingIPBinding
 type FloatingIPBindingSpec struct {
 	// The floating IP address to bind nodes to. i.e. "1.2.3.4"
 	FloatingIP string `json:"floatingIP"`
 
+	// An optional mode choosing what the floating IP is bound to. Either Nodes, which
+	// selects Kubernetes nodes with the NodeSelector, or DropletTag, which selects any
+	// droplets with the DropletTag.
+	// Defaults to Nodes
+	// +kubebuilder:default:="Nodes"
+	// +optional
+	Target TargetMode `json:"target,omitempty"`
+
+	// The DigitalOcean tag used to select droplets when Target is DropletTag, which
+	// requires it
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	DropletTag string `json:"dropletTag,omitempty"`
+
 	// An optional LabelSelector to select nodes. Defaults to all nodes.
 	// A label selector is a label query over a set of resources. The result of matchLabels
 	// and matchExpressions are ANDed. An empty label selector matches all objects. A null
@@ -41,17 +190,249 @@ type FloatingIPBindingSpec struct {
 	// +nullable
 	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
 
-	// An optional policy to choose a node from those that match the NodeSelector
+	// An optional policy to choose a node from those that match the NodeSelector, or a
+	// droplet from those with the DropletTag
 	// Defaults to Newest
 	// +kubebuilder:default:="Newest"
 	// +optional
 	NodeSelectorPolicy NodeSelectorPolicy `json:"nodeSelectorPolicy,omitempty"`
+
+	// An optional policy controlling when the floating IP may be taken from the droplet
+	// it is currently assigned to. One of Always, IfUnassigned or IfClusterNode.
+	// Defaults to Always
+	// +kubebuilder:default:="Always"
+	// +optional
+	TakeoverPolicy TakeoverPolicy `json:"takeoverPolicy,omitempty"`
+
+	// An optional policy for when no nodes match the NodeSelector. One of Keep,
+	// Unassign or FallbackSelector.
+	// Defaults to Keep
+	// +kubebuilder:default:="Keep"
+	// +optional
+	WhenNoCandidates WhenNoCandidatesPolicy `json:"whenNoCandidates,omitempty"`
+
+	// An optional LabelSelector used to select nodes when WhenNoCandidates is
+	// FallbackSelector and no nodes match the NodeSelector. Defaults to all nodes.
+	// +optional
+	// +nullable
+	FallbackNodeSelector *metav1.LabelSelector `json:"fallbackNodeSelector,omitempty"`
+
+	// An optional reference to a Secret holding the DigitalOcean API token used to
+	// manage this floating IP. Defaults to the controller's global token.
+	// +optional
+	CredentialsRef *CredentialsReference `json:"credentialsRef,omitempty"`
+
+	// An optional taint effect. When set the node holding the floating IP is tainted
+	// with the binding's node label key and this effect.
+	// +kubebuilder:validation:Enum=NoSchedule;PreferNoSchedule;NoExecute
+	// +optional
+	NodeTaintEffect corev1.TaintEffect `json:"nodeTaintEffect,omitempty"`
+
+	// An optional A record in a DigitalOcean domain kept pointing at the floating IP
+	// +optional
+	DNS *DNS `json:"dns,omitempty"`
+
+	// An optional Service in the same namespace whose status.loadBalancer.ingress lists
+	// the floating IP while it is assigned. A LoadBalancer Service must have the
+	// loadBalancerClass digitalocean.smirlwebs.com/floating-ip.
+	// +optional
+	ServiceRef *ServiceReference `json:"serviceRef,omitempty"`
+
+	// The ID of an optional DigitalOcean Cloud Firewall which the droplet holding the
+	// floating IP is added to, and previous droplets removed from
+	// +optional
+	FirewallID string `json:"firewallID,omitempty"`
+
+	// An optional policy for the floating IP and DNS record when the binding is deleted.
+	// One of Retain or Release.
+	// Defaults to Retain
+	// +kubebuilder:default:="Retain"
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Optional egress traffic which the node agent routes through the floating IP on the
+	// node holding it
+	// +optional
+	Egress *Egress `json:"egress,omitempty"`
+
+	// If true the controller selects a droplet and records it in status.plannedDroplet
+	// without assigning or unassigning the floating IP
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// The name of the controller instance which manages the binding, so that several
+	// instances can run in one cluster, e.g. one per DigitalOcean account.
+	// Defaults to digitalocean.smirlwebs.com/floating-ip-controller
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	ControllerName string `json:"controllerName,omitempty"`
+
+	// The FloatingIPClass the binding was created from for a FloatingIPClaim. The class's
+	// credentials are used instead of CredentialsRef.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+}
+
+// DropletReference identifies a droplet
+type DropletReference struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// Reassignment records a manual failover requested with the ReassignAnnotation
+type Reassignment struct {
+	// The annotation value which requested the reassignment
+	Value string `json:"value"`
+	// When the reassignment was made
+	Time metav1.Time `json:"time"`
+	// The droplet the floating IP was moved from
+	// +optional
+	From *DropletReference `json:"from,omitempty"`
+	// The droplet the floating IP was moved to
+	// +optional
+	To *DropletReference `json:"to,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Policy;NodeGone;Drift;Manual;Pinned
+type AssignmentReason string
+
+const (
+	// ReasonPolicy means the NodeSelectorPolicy chose a different node
+	ReasonPolicy AssignmentReason = "Policy"
+	// ReasonNodeGone means the previously assigned node, or tagged droplet, no longer exists
+	ReasonNodeGone AssignmentReason = "NodeGone"
+	// ReasonDrift means the floating IP had been moved outside the controller and was moved back
+	ReasonDrift AssignmentReason = "Drift"
+	// ReasonManual means the move was requested with the ReassignAnnotation
+	ReasonManual AssignmentReason = "Manual"
+	// ReasonPinned means the floating IP was moved back to the node it is pinned to by
+	// the Random policy or a manual reassignment
+	ReasonPinned AssignmentReason = "Pinned"
+)
+
+// AssignmentRecord is an entry in the assignment history of a floating IP
+type AssignmentRecord struct {
+	DropletID   int    `json:"dropletID"`
+	DropletName string `json:"dropletName,omitempty"`
+	// The node backed by the droplet
+	// +optional
+	Node string `json:"node,omitempty"`
+	// When the floating IP was assigned to the droplet
+	Start metav1.Time `json:"start"`
+	// When the floating IP was moved away from the droplet. Unset for the current assignment
+	// +optional
+	End *metav1.Time `json:"end,omitempty"`
+	// Why the floating IP was assigned to the droplet
+	Reason AssignmentReason `json:"reason"`
+	// The ID of the DigitalOcean action which assigned the floating IP
+	// +optional
+	ActionID int `json:"actionID,omitempty"`
+}
+
+// Candidate is a node considered when selecting a droplet for the floating IP
+type Candidate struct {
+	// The name of the node
+	Name string `json:"name"`
+	// The droplet backing the node
+	// +optional
+	DropletID int `json:"dropletID,omitempty"`
+	// When the node was created, which gives its age
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+	// The rank of the node under the NodeSelectorPolicy. The selected node has the
+	// highest score and nodes which were filtered out have a score of 0
+	Score int `json:"score"`
+	// Why the node was filtered out, if it was
+	// +optional
+	FilteredReason string `json:"filteredReason,omitempty"`
+}
+
+// Anchor is the anchor network of a droplet which floating IP traffic is routed through
+type Anchor struct {
+	// The droplet the anchor network belongs to
+	DropletID int `json:"dropletID"`
+	// The anchor IP address of the droplet
+	IPAddress string `json:"ipAddress"`
+	// The gateway of the anchor network
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+	// The netmask of the anchor network
+	// +optional
+	Netmask string `json:"netmask,omitempty"`
+}
+
+// DNSRecordReference identifies a record in a DigitalOcean domain
+type DNSRecordReference struct {
+	Domain string `json:"domain"`
+	Name   string `json:"name"`
+	ID     int    `json:"id"`
+}
+
+// PublishedService identifies a Service whose status lists the floating IP
+type PublishedService struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// FirewallMembership identifies a droplet added to a Cloud Firewall
+type FirewallMembership struct {
+	ID        string `json:"id"`
+	DropletID int    `json:"dropletID"`
 }
 
 // FloatingIPBindingStatus defines the observed state of FloatingIPBinding
 type FloatingIPBindingStatus struct {
 	AssignedDropletID   int    `json:"assignedDropletID,omitempty"`
 	AssignedDropletName string `json:"assignedDropletName,omitempty"`
+
+	// The floating IP the controller last assigned for the binding. When spec.floatingIP
+	// changes, the DeletionPolicy is applied to this IP before the new one is assigned.
+	// +optional
+	ManagedIP string `json:"managedIP,omitempty"`
+
+	// The droplet the floating IP would be assigned to when running in dry-run mode
+	// +optional
+	PlannedDroplet *DropletReference `json:"plannedDroplet,omitempty"`
+
+	// The anchor network of the droplet holding the floating IP. Egress traffic routed
+	// through the anchor gateway leaves from the floating IP.
+	// +optional
+	Anchor *Anchor `json:"anchor,omitempty"`
+
+	// The DNS record managed for the binding
+	// +optional
+	DNSRecord *DNSRecordReference `json:"dnsRecord,omitempty"`
+
+	// The Service the floating IP is published in
+	// +optional
+	Service *PublishedService `json:"service,omitempty"`
+
+	// The droplet added to the Cloud Firewall for the binding
+	// +optional
+	Firewall *FirewallMembership `json:"firewall,omitempty"`
+
+	// The last manual reassignment requested with the reassign annotation
+	// +optional
+	LastReassign *Reassignment `json:"lastReassign,omitempty"`
+
+	// The resourceVersion of the credentials Secret when the binding was stalled. The
+	// binding is retried once the Secret changes.
+	// +optional
+	StalledCredentialsVersion string `json:"stalledCredentialsVersion,omitempty"`
+
+	// The most recent assignments of the floating IP, oldest first
+	// +optional
+	History []AssignmentRecord `json:"history,omitempty"`
+
+	// The nodes considered by the last reconcile in order of preference, followed by
+	// the nodes which were filtered out
+	// +optional
+	Candidates []Candidate `json:"candidates,omitempty"`
+
+	// Conditions represent the latest available observations of the binding's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -60,6 +441,7 @@ type FloatingIPBindingStatus struct {
 // +kubebuilder:printcolumn:name="FLOATING_IP",type=string,JSONPath=`.spec.floatingIP`
 // +kubebuilder:printcolumn:name="ASSIGNED_DROPLET_ID",type=string,JSONPath=`.status.assignedDropletID`
 // +kubebuilder:printcolumn:name="ASSIGNED_DROPLET_NAME",type=string,JSONPath=`.status.assignedDropletName`
+// +kubebuilder:printcolumn:name="CONTROLLER",type=string,JSONPath=`.spec.controllerName`,priority=1
 // +kubebuilder:subresource:status
 type FloatingIPBinding struct {
 	metav1.TypeMeta   `json:",inline"`
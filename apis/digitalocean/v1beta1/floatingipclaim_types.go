@@ -0,0 +1,101 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:validation:Enum=Pending;Bound
+type ClaimPhase string
+
+const (
+	// ClaimPending claims are waiting for a floating IP
+	ClaimPending ClaimPhase = "Pending"
+	// ClaimBound claims have a floating IP and a FloatingIPBinding
+	ClaimBound ClaimPhase = "Bound"
+)
+
+// ConditionBound is True when the claim is bound to a floating IP
+const ConditionBound string = "Bound"
+
+// FloatingIPClaimSpec defines the desired state of FloatingIPClaim
+type FloatingIPClaimSpec struct {
+	// The name of the FloatingIPClass to claim a floating IP from
+	// +kubebuilder:validation:MinLength=1
+	ClassName string `json:"className"`
+
+	// An optional LabelSelector to select nodes. It is combined with the class's NodeSelector.
+	// Defaults to all nodes allowed by the class.
+	// +optional
+	// +nullable
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+}
+
+// FloatingIPClaimStatus defines the observed state of FloatingIPClaim
+type FloatingIPClaimStatus struct {
+	// Pending or Bound
+	// +optional
+	Phase ClaimPhase `json:"phase,omitempty"`
+
+	// The floating IP bound to the claim
+	// +optional
+	FloatingIP string `json:"floatingIP,omitempty"`
+
+	// The name of the FloatingIPBinding created for the claim
+	// +optional
+	BindingName string `json:"bindingName,omitempty"`
+
+	// The droplet holding the floating IP
+	// +optional
+	AssignedDropletName string `json:"assignedDropletName,omitempty"`
+
+	// Conditions represent the latest available observations of the claim's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// FloatingIPClaim requests a floating IP from a FloatingIPClass
+// +kubebuilder:printcolumn:name="CLASS",type=string,JSONPath=`.spec.className`
+// +kubebuilder:printcolumn:name="PHASE",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="FLOATING_IP",type=string,JSONPath=`.status.floatingIP`
+// +kubebuilder:printcolumn:name="ASSIGNED_DROPLET_NAME",type=string,JSONPath=`.status.assignedDropletName`
+type FloatingIPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FloatingIPClaimSpec   `json:"spec,omitempty"`
+	Status FloatingIPClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// FloatingIPClaimList contains a list of FloatingIPClaim
+type FloatingIPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FloatingIPClaim `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FloatingIPClaim{}, &FloatingIPClaimList{})
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ClassCredentialsReference refers to a key in a Secret holding a DigitalOcean API token
+type ClassCredentialsReference struct {
+	// The namespace of the Secret
+	Namespace string `json:"namespace"`
+
+	// The name of the Secret
+	Name string `json:"name"`
+
+	// The key in the Secret holding the token
+	// Defaults to DO_TOKEN
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// FloatingIPClassSpec defines the desired state of FloatingIPClass
+type FloatingIPClassSpec struct {
+	// The floating IPs which claims of the class can be bound to
+	// +optional
+	FloatingIPs []string `json:"floatingIPs,omitempty"`
+
+	// If true a floating IP is reserved in the Region when no floating IP is available
+	// +optional
+	Provision bool `json:"provision,omitempty"`
+
+	// The region floating IPs are reserved in, e.g. lon1. Required when Provision is true.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// An optional reference to a Secret holding the DigitalOcean API token of the
+	// account owning the floating IPs. Defaults to the controller's token.
+	// +optional
+	CredentialsRef *ClassCredentialsReference `json:"credentialsRef,omitempty"`
+
+	// The namespaces claims of the class may be created in. Defaults to all namespaces.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// An optional LabelSelector the nodes of every claim must match, in addition to the
+	// claim's own NodeSelector
+	// +optional
+	// +nullable
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// The DeletionPolicy of the bindings created for claims. Release returns the floating
+	// IP unassigned to the class when the claim is deleted.
+	// Defaults to Release
+	// +kubebuilder:default:="Release"
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// The controllerName of the bindings created for claims
+	// Defaults to digitalocean.smirlwebs.com/floating-ip-controller
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	ControllerName string `json:"controllerName,omitempty"`
+}
+
+// ClaimReference identifies the FloatingIPClaim a floating IP is allocated to
+type ClaimReference struct {
+	// The namespace of the claim
+	Namespace string `json:"namespace"`
+
+	// The name of the claim
+	Name string `json:"name"`
+
+	// The UID of the claim, so a claim recreated with the same name isn't mistaken for it
+	UID types.UID `json:"uid"`
+}
+
+// FloatingIPAllocation records the claim a floating IP of the class is bound to
+type FloatingIPAllocation struct {
+	// The floating IP
+	FloatingIP string `json:"floatingIP"`
+
+	// The claim the floating IP is bound to
+	ClaimRef ClaimReference `json:"claimRef"`
+}
+
+// FloatingIPClassStatus defines the observed state of FloatingIPClass
+type FloatingIPClassStatus struct {
+	// The floating IPs reserved for the class. They are bound to claims like FloatingIPs.
+	// +optional
+	ProvisionedIPs []string `json:"provisionedIPs,omitempty"`
+
+	// The floating IPs of the class bound to claims. Each floating IP is allocated to one
+	// claim, and the list is updated with optimistic concurrency so concurrent claims
+	// aren't bound to the same floating IP.
+	// +optional
+	Allocations []FloatingIPAllocation `json:"allocations,omitempty"`
+}
+
+// AllocationFor returns the floating IP allocated to the claim with the UID, or an empty
+// string if none is
+func (s *FloatingIPClassStatus) AllocationFor(uid types.UID) string {
+	for _, allocation := range s.Allocations {
+		if allocation.ClaimRef.UID == uid {
+			return allocation.FloatingIP
+		}
+	}
+	return ""
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+
+// FloatingIPClass is a pool of floating IPs which FloatingIPClaims are bound to
+// +kubebuilder:printcolumn:name="REGION",type=string,JSONPath=`.spec.region`
+// +kubebuilder:printcolumn:name="PROVISION",type=boolean,JSONPath=`.spec.provision`
+type FloatingIPClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FloatingIPClassSpec   `json:"spec,omitempty"`
+	Status FloatingIPClassStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// FloatingIPClassList contains a list of FloatingIPClass
+type FloatingIPClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FloatingIPClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FloatingIPClass{}, &FloatingIPClassList{})
+}
+
+// AllowsNamespace returns true when claims of the class may be created in the namespace
+func (c *FloatingIPClass) AllowsNamespace(namespace string) bool {
+	if len(c.Spec.AllowedNamespaces) == 0 {
+		return true
+	}
+	for _, allowed := range c.Spec.AllowedNamespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:validation:Enum=Assigned;Conflict;Failed
+type NotificationEvent string
+
+const (
+	// NotifyAssigned is sent when the floating IP moves to another droplet
+	NotifyAssigned NotificationEvent = "Assigned"
+	// NotifyConflict is sent when the TakeoverPolicy refuses to move the floating IP
+	NotifyConflict NotificationEvent = "Conflict"
+	// NotifyFailed is sent when a binding has failed to reconcile repeatedly
+	NotifyFailed NotificationEvent = "Failed"
+)
+
+// SecretKeyReference refers to a key in a Secret in the same namespace
+type SecretKeyReference struct {
+	// The name of the Secret
+	Name string `json:"name"`
+	// The key in the Secret
+	Key string `json:"key"`
+}
+
+// FloatingIPNotifierSpec defines the desired state of FloatingIPNotifier
+type FloatingIPNotifierSpec struct {
+	// The URL a JSON payload is POSTed to for each notification
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// An optional LabelSelector for the bindings in the namespace to notify about.
+	// Defaults to all bindings.
+	// +optional
+	// +nullable
+	BindingSelector *metav1.LabelSelector `json:"bindingSelector,omitempty"`
+
+	// The events to notify about. Defaults to all events.
+	// +optional
+	Events []NotificationEvent `json:"events,omitempty"`
+
+	// An optional reference to a Secret key holding the key used to sign the payload
+	// with HMAC-SHA256. The signature is sent in the X-Signature-256 header.
+	// +optional
+	SecretRef *SecretKeyReference `json:"secretRef,omitempty"`
+
+	// The number of times a failed delivery is retried with exponential backoff.
+	// Defaults to 5
+	// +kubebuilder:default:=5
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRetries int `json:"maxRetries,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// FloatingIPNotifier sends webhook notifications about the FloatingIPBindings in its namespace
+// +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.spec.url`
+type FloatingIPNotifier struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec FloatingIPNotifierSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// FloatingIPNotifierList contains a list of FloatingIPNotifier
+type FloatingIPNotifierList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FloatingIPNotifier `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FloatingIPNotifier{}, &FloatingIPNotifierList{})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// agent runs on every node as a DaemonSet. On the node holding a floating IP it routes
+// the binding's egress traffic through the droplet's anchor gateway.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/controllers/agent"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/egress"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+
+	utilruntime.Must(digitaloceanv1beta1.AddToScheme(scheme))
+}
+
+func main() {
+	var nodeName string
+	var metricsAddr string
+	var probeAddr string
+	var table int
+	var priority int
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"),
+		"The name of the node the agent runs on. Defaults to the NODE_NAME environment variable.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", "0",
+		"The address the metric endpoint binds to. Disabled by default as the agent uses the host network.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8082",
+		"The address the probe endpoint binds to.")
+	flag.IntVar(&table, "route-table", egress.DefaultTable,
+		"The routing table holding the route through the anchor gateway.")
+	flag.IntVar(&priority, "rule-priority", egress.DefaultPriority,
+		"The priority of the rules sending egress traffic to the routing table. The next priority is also used.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if nodeName == "" {
+		setupLog.Info("Could not find the node name. Set --node-name or NODE_NAME.")
+		os.Exit(1)
+	}
+
+	router, err := egress.NewRouter(table, priority)
+	if err != nil {
+		setupLog.Error(err, "unable to create router")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: probeAddr,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+
+	if err = (&agent.EgressReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("agent").WithName("Egress"),
+		NodeName: nodeName,
+		Router:   router,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Egress")
+		os.Exit(1)
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting agent", "node", nodeName)
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running agent")
+		os.Exit(1)
+	}
+}
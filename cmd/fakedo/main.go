@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// fakedo runs a fake DigitalOcean API server for developing the controller
+// without a DigitalOcean account. Run the manager with --do-api-url pointing at it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/digitalocean/godo"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/fakedo"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+var setupLog = ctrl.Log.WithName("fakedo")
+
+// seed is the initial state loaded from --seed
+type seed struct {
+	Droplets    []godo.Droplet `json:"droplets"`
+	FloatingIPs []struct {
+		IP        string `json:"ip"`
+		DropletID int    `json:"droplet_id"`
+	} `json:"floating_ips"`
+	Domains   []string `json:"domains"`
+	Firewalls []string `json:"firewalls"`
+}
+
+func main() {
+	var addr, token, region, seedFile string
+	var actionDuration time.Duration
+	flag.StringVar(&addr, "addr", ":8090", "The address the fake API binds to.")
+	flag.StringVar(&token, "token", "", "If set, the only API token accepted. Omit to accept any token.")
+	flag.StringVar(&region, "region", "nyc1", "The region of floating IPs created without one.")
+	flag.StringVar(&seedFile, "seed", "",
+		"A JSON file of initial droplets and floating IPs, "+
+			`e.g. {"droplets": [{"id": 1, "name": "node-a"}], "floating_ips": [{"ip": "1.2.3.4", "droplet_id": 1}], `+
+			`"domains": ["example.com"], "firewalls": ["fw-1"]}`)
+	flag.DurationVar(&actionDuration, "action-duration", time.Second*5,
+		"How long floating IP actions stay in-progress. The floating IP is locked until they complete.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	fake := provider.NewFake()
+	fake.ActionDuration = actionDuration
+	fake.Region = region
+
+	if seedFile != "" {
+		data, err := os.ReadFile(seedFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read seed file")
+			os.Exit(1)
+		}
+		var s seed
+		if err := json.Unmarshal(data, &s); err != nil {
+			setupLog.Error(err, "unable to parse seed file")
+			os.Exit(1)
+		}
+		for _, droplet := range s.Droplets {
+			fake.CreateDroplet(droplet)
+		}
+		for _, floatingIP := range s.FloatingIPs {
+			fake.AddFloatingIP(floatingIP.IP, floatingIP.DropletID)
+		}
+		for _, domain := range s.Domains {
+			fake.AddDomain(domain)
+		}
+		for _, firewallID := range s.Firewalls {
+			fake.AddFirewall(firewallID)
+		}
+	}
+
+	server := fakedo.NewServer(fake)
+	server.Token = token
+
+	setupLog.Info("starting fake DigitalOcean API", "addr", addr)
+	if err := http.ListenAndServe(addr, server); err != nil {
+		setupLog.Error(err, "problem running fake DigitalOcean API")
+		os.Exit(1)
+	}
+}
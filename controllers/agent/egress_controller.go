@@ -0,0 +1,149 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package agent contains the controllers run by the node agent on every node
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/egress"
+)
+
+// ResyncInterval is how often the routing is reapplied in case it was changed outside the agent
+const ResyncInterval = time.Minute
+
+// EgressReconciler routes egress traffic through the floating IP on the node holding it
+// and removes the routing from every other node
+type EgressReconciler struct {
+	client.Client
+	Log logr.Logger
+	// NodeName is the name of the node the agent runs on
+	NodeName string
+	Router   egress.Router
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *EgressReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("egress").
+		For(&digitaloceanv1beta1.FloatingIPBinding{}).
+		Complete(r)
+}
+
+// Reconcile applies the routing for the whole node whichever binding changed, as only
+// one floating IP can be assigned to a droplet
+func (r *EgressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("node", r.NodeName)
+
+	var bindings digitaloceanv1beta1.FloatingIPBindingList
+	if err := r.List(ctx, &bindings); err != nil {
+		log.Error(err, "Failed to list floating IP bindings")
+		return ctrl.Result{}, err
+	}
+
+	binding := r.HeldBinding(log, bindings.Items)
+	if binding == nil {
+		if err := r.Router.Apply(nil); err != nil {
+			log.Error(err, "Failed to remove egress routing")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: ResyncInterval}, nil
+	}
+	log = log.WithValues("floatingipbinding", client.ObjectKeyFromObject(binding))
+
+	anchor := binding.Status.Anchor
+	route := &egress.Route{
+		AnchorIP:    anchor.IPAddress,
+		Gateway:     anchor.Gateway,
+		SourceCIDRs: binding.Spec.Egress.SourceCIDRs,
+	}
+	condition := metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionEgressReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: binding.Generation,
+		Reason:             "Routed",
+		Message: fmt.Sprintf("Egress from %s is routed through %s on node %s",
+			strings.Join(route.SourceCIDRs, ", "), anchor.Gateway, r.NodeName),
+	}
+	applyErr := r.Router.Apply(route)
+	if applyErr != nil {
+		log.Error(applyErr, "Failed to route egress")
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RouteFailed"
+		condition.Message = fmt.Sprintf("Failed to route egress on node %s: %s", r.NodeName, applyErr)
+	}
+
+	// Report readiness on the binding
+	current := meta.FindStatusCondition(binding.Status.Conditions, condition.Type)
+	if current == nil || current.Status != condition.Status || current.Message != condition.Message ||
+		current.ObservedGeneration != condition.ObservedGeneration {
+		meta.SetStatusCondition(&binding.Status.Conditions, condition)
+		if err := r.Status().Update(ctx, binding); err != nil {
+			log.Error(err, "Failed to update status")
+			return ctrl.Result{}, err
+		}
+	}
+	if applyErr != nil {
+		return ctrl.Result{}, applyErr
+	}
+	return ctrl.Result{RequeueAfter: ResyncInterval}, nil
+}
+
+// HeldBinding returns the binding with egress whose floating IP is assigned to this
+// node, or nil if there isn't one
+func (r *EgressReconciler) HeldBinding(
+	log logr.Logger,
+	bindings []digitaloceanv1beta1.FloatingIPBinding,
+) *digitaloceanv1beta1.FloatingIPBinding {
+	var held []*digitaloceanv1beta1.FloatingIPBinding
+	for i := range bindings {
+		binding := &bindings[i]
+		status := binding.Status
+		if binding.Spec.Egress == nil || status.AssignedDropletName != r.NodeName {
+			continue
+		}
+		if status.Anchor == nil || status.Anchor.DropletID != status.AssignedDropletID {
+			log.Info("Anchor network not known yet. Skipping.", "floatingipbinding", client.ObjectKeyFromObject(binding))
+			continue
+		}
+		held = append(held, binding)
+	}
+	if len(held) == 0 {
+		return nil
+	}
+
+	// A droplet can only hold one floating IP, so any others are stale
+	sort.Slice(held, func(i, j int) bool {
+		return client.ObjectKeyFromObject(held[i]).String() < client.ObjectKeyFromObject(held[j]).String()
+	})
+	if len(held) > 1 {
+		log.Info("Multiple bindings are assigned to this node. Routing the first.",
+			"floatingipbinding", client.ObjectKeyFromObject(held[0]))
+	}
+	return held[0]
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/egress"
+)
+
+// fakeRouter records the routes applied
+type fakeRouter struct {
+	applied []*egress.Route
+	err     error
+}
+
+func (f *fakeRouter) Apply(route *egress.Route) error {
+	f.applied = append(f.applied, route)
+	return f.err
+}
+
+func heldBinding(name string, node string, dropletID int, anchorDropletID int) digitaloceanv1beta1.FloatingIPBinding {
+	binding := digitaloceanv1beta1.FloatingIPBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+			FloatingIP: "203.0.113.1",
+			Egress:     &digitaloceanv1beta1.Egress{SourceCIDRs: []string{"10.244.0.0/16"}},
+		},
+	}
+	binding.Status.AssignedDropletName = node
+	binding.Status.AssignedDropletID = dropletID
+	if anchorDropletID != 0 {
+		binding.Status.Anchor = &digitaloceanv1beta1.Anchor{
+			DropletID: anchorDropletID,
+			IPAddress: "10.20.0.5",
+			Gateway:   "10.20.0.1",
+		}
+	}
+	return binding
+}
+
+func TestHeldBinding(t *testing.T) {
+	withoutEgress := heldBinding("no-egress", "node1", 1, 1)
+	withoutEgress.Spec.Egress = nil
+
+	tests := []struct {
+		name     string
+		bindings []digitaloceanv1beta1.FloatingIPBinding
+		want     string
+	}{
+		{
+			name:     "held by this node",
+			bindings: []digitaloceanv1beta1.FloatingIPBinding{heldBinding("main", "node1", 1, 1)},
+			want:     "main",
+		},
+		{
+			name:     "held by another node",
+			bindings: []digitaloceanv1beta1.FloatingIPBinding{heldBinding("main", "node2", 2, 2)},
+		},
+		{
+			name:     "without egress",
+			bindings: []digitaloceanv1beta1.FloatingIPBinding{withoutEgress},
+		},
+		{
+			name:     "anchor not known yet",
+			bindings: []digitaloceanv1beta1.FloatingIPBinding{heldBinding("main", "node1", 1, 0)},
+		},
+		{
+			name:     "anchor of the previous droplet",
+			bindings: []digitaloceanv1beta1.FloatingIPBinding{heldBinding("main", "node1", 1, 2)},
+		},
+		{
+			name: "several held bindings route the first by name",
+			bindings: []digitaloceanv1beta1.FloatingIPBinding{
+				heldBinding("second", "node1", 1, 1),
+				heldBinding("first", "node1", 1, 1),
+			},
+			want: "first",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &EgressReconciler{NodeName: "node1"}
+			got := r.HeldBinding(ctrl.Log, tt.bindings)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("HeldBinding() = %s, want nil", got.Name)
+				}
+				return
+			}
+			if got == nil || got.Name != tt.want {
+				t.Errorf("HeldBinding() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := digitaloceanv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "main"}}
+
+	t.Run("routes the held binding and reports it", func(t *testing.T) {
+		binding := heldBinding("main", "node1", 1, 1)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&binding).Build()
+		router := &fakeRouter{}
+		r := &EgressReconciler{Client: c, Log: ctrl.Log, NodeName: "node1", Router: router}
+
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if len(router.applied) != 1 || router.applied[0] == nil {
+			t.Fatalf("applied = %v, want one route", router.applied)
+		}
+		route := router.applied[0]
+		if route.AnchorIP != "10.20.0.5" || route.Gateway != "10.20.0.1" || route.SourceCIDRs[0] != "10.244.0.0/16" {
+			t.Errorf("route = %+v", route)
+		}
+		if err := c.Get(ctx, req.NamespacedName, &binding); err != nil {
+			t.Fatal(err)
+		}
+		if !meta.IsStatusConditionTrue(binding.Status.Conditions, digitaloceanv1beta1.ConditionEgressReady) {
+			t.Errorf("conditions = %v, want EgressReady", binding.Status.Conditions)
+		}
+	})
+
+	t.Run("removes routing from other nodes", func(t *testing.T) {
+		binding := heldBinding("main", "node2", 2, 2)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&binding).Build()
+		router := &fakeRouter{}
+		r := &EgressReconciler{Client: c, Log: ctrl.Log, NodeName: "node1", Router: router}
+
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if len(router.applied) != 1 || router.applied[0] != nil {
+			t.Errorf("applied = %v, want the routing removed", router.applied)
+		}
+	})
+
+	t.Run("reports routing failures", func(t *testing.T) {
+		binding := heldBinding("main", "node1", 1, 1)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&binding).Build()
+		router := &fakeRouter{err: errors.New("no link has the anchor IP")}
+		r := &EgressReconciler{Client: c, Log: ctrl.Log, NodeName: "node1", Router: router}
+
+		if _, err := r.Reconcile(ctx, req); err == nil {
+			t.Fatal("Reconcile() error = nil, want the routing error")
+		}
+		if err := c.Get(ctx, req.NamespacedName, &binding); err != nil {
+			t.Fatal(err)
+		}
+		condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionEgressReady)
+		if condition == nil || condition.Reason != "RouteFailed" {
+			t.Errorf("condition = %v, want RouteFailed", condition)
+		}
+	})
+}
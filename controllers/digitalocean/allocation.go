@@ -0,0 +1,301 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// bindIP returns the floating IP of the class allocated to the claim, allocating one
+// which isn't used by another claim or binding and reserving a new one when the class
+// allows it. An empty IP is returned with the reason when none is available.
+func (r *FloatingIPClaimReconciler) bindIP(
+	ctx context.Context,
+	log logr.Logger,
+	class *digitaloceanv1beta1.FloatingIPClass,
+	claim *digitaloceanv1beta1.FloatingIPClaim,
+) (string, string, error) {
+	// Floating IPs reserved earlier are recorded before another is reserved
+	if err := r.recordProvisionedIPs(ctx, log, class.Name); err != nil {
+		return "", "", err
+	}
+	ip, err := r.allocateIP(ctx, class.Name, claim)
+	if err != nil || ip != "" {
+		return ip, "", err
+	}
+
+	if !class.Spec.Provision {
+		return "", "NoFloatingIPAvailable", nil
+	}
+	if class.Spec.Region == "" {
+		return "", "NoRegion", nil
+	}
+	doProvider, err := classProvider(ctx, r.apiReader(), r.Provider, &r.ProviderCache, class)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return "", "", err
+	}
+	floatingIP, err := doProvider.CreateFloatingIP(ctx, class.Spec.Region)
+	if err != nil {
+		log.Error(err, "Failed to reserve floatingIP", "region", class.Spec.Region)
+		return "", "", err
+	}
+	log.Info("Reserved floatingIP", "floatingIP", floatingIP.IP, "region", class.Spec.Region)
+	r.Recorder.Eventf(claim, v1.EventTypeNormal, "Provisioned",
+		"Reserved floating IP %s in %s", floatingIP.IP, class.Spec.Region)
+
+	// Record the floating IP on the class so it is reused once the claim is deleted
+	r.unrecorded.add(class.Name, floatingIP.IP)
+	if err := r.recordProvisionedIPs(ctx, log, class.Name); err != nil {
+		return "", "", err
+	}
+	ip, err = r.allocateIP(ctx, class.Name, claim)
+	if err != nil || ip != "" {
+		return ip, "", err
+	}
+	// Another claim was allocated the floating IP first
+	return "", "NoFloatingIPAvailable", nil
+}
+
+// allocateIP records a free floating IP of the class against the claim in the class's
+// status.allocations and returns it, or returns the floating IP already allocated to the
+// claim. The class is read from the API server and updated with optimistic concurrency,
+// so a floating IP is never allocated to two claims. An empty IP is returned when none
+// is free.
+func (r *FloatingIPClaimReconciler) allocateIP(
+	ctx context.Context,
+	className string,
+	claim *digitaloceanv1beta1.FloatingIPClaim,
+) (string, error) {
+	var ip string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ip = ""
+		class := &digitaloceanv1beta1.FloatingIPClass{}
+		if err := r.apiReader().Get(ctx, types.NamespacedName{Name: className}, class); err != nil {
+			return err
+		}
+		if allocated := class.Status.AllocationFor(claim.UID); allocated != "" {
+			ip = allocated
+			return nil
+		}
+		used, err := r.usedIPs(ctx, class, claim)
+		if err != nil {
+			return err
+		}
+		pool := append(append([]string{}, class.Spec.FloatingIPs...), class.Status.ProvisionedIPs...)
+		for _, candidate := range pool {
+			if !used[candidate] {
+				ip = candidate
+				break
+			}
+		}
+		if ip == "" {
+			return nil
+		}
+		class.Status.Allocations = append(class.Status.Allocations, digitaloceanv1beta1.FloatingIPAllocation{
+			FloatingIP: ip,
+			ClaimRef: digitaloceanv1beta1.ClaimReference{
+				Namespace: claim.Namespace,
+				Name:      claim.Name,
+				UID:       claim.UID,
+			},
+		})
+		return r.Status().Update(ctx, class)
+	})
+	if err != nil {
+		return "", err
+	}
+	return ip, nil
+}
+
+// recordAllocation records the floating IP of a claim bound before allocations were
+// recorded in the class's status.allocations, unless another claim has been allocated it
+func (r *FloatingIPClaimReconciler) recordAllocation(
+	ctx context.Context,
+	log logr.Logger,
+	className string,
+	claim *digitaloceanv1beta1.FloatingIPClaim,
+) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		class := &digitaloceanv1beta1.FloatingIPClass{}
+		if err := r.apiReader().Get(ctx, types.NamespacedName{Name: className}, class); err != nil {
+			return err
+		}
+		if class.Status.AllocationFor(claim.UID) != "" {
+			return nil
+		}
+		for _, allocation := range class.Status.Allocations {
+			if allocation.FloatingIP == claim.Status.FloatingIP {
+				log.Info("Floating IP of the claim is allocated to another claim",
+					"floatingIP", claim.Status.FloatingIP, "otherClaim", allocation.ClaimRef.Name)
+				return nil
+			}
+		}
+		class.Status.Allocations = append(class.Status.Allocations, digitaloceanv1beta1.FloatingIPAllocation{
+			FloatingIP: claim.Status.FloatingIP,
+			ClaimRef: digitaloceanv1beta1.ClaimReference{
+				Namespace: claim.Namespace,
+				Name:      claim.Name,
+				UID:       claim.UID,
+			},
+		})
+		return r.Status().Update(ctx, class)
+	})
+	if err != nil {
+		log.Error(err, "Failed to record the claim's floatingIP on class")
+	}
+	return err
+}
+
+// recordProvisionedIPs appends the floating IPs reserved for the class which haven't
+// been recorded yet to its status.provisionedIPs
+func (r *FloatingIPClaimReconciler) recordProvisionedIPs(ctx context.Context, log logr.Logger, className string) error {
+	ips := r.unrecorded.get(className)
+	if len(ips) == 0 {
+		return nil
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		class := &digitaloceanv1beta1.FloatingIPClass{}
+		if err := r.apiReader().Get(ctx, types.NamespacedName{Name: className}, class); err != nil {
+			return err
+		}
+		recorded := map[string]bool{}
+		for _, ip := range class.Status.ProvisionedIPs {
+			recorded[ip] = true
+		}
+		for _, ip := range ips {
+			if !recorded[ip] {
+				class.Status.ProvisionedIPs = append(class.Status.ProvisionedIPs, ip)
+			}
+		}
+		return r.Status().Update(ctx, class)
+	})
+	if err != nil {
+		log.Error(err, "Failed to record provisioned floatingIPs on class", "floatingIPs", ips)
+		return err
+	}
+	r.unrecorded.remove(className, ips)
+	return nil
+}
+
+// usedIPs returns the floating IPs allocated or bound to other claims, or managed by
+// bindings which don't belong to the claim. Allocations of claims which no longer exist
+// are dropped from the class's status.
+func (r *FloatingIPClaimReconciler) usedIPs(
+	ctx context.Context,
+	class *digitaloceanv1beta1.FloatingIPClass,
+	claim *digitaloceanv1beta1.FloatingIPClaim,
+) (map[string]bool, error) {
+	used := map[string]bool{}
+
+	var claims digitaloceanv1beta1.FloatingIPClaimList
+	if err := r.apiReader().List(ctx, &claims); err != nil {
+		return nil, err
+	}
+	exists := map[types.UID]bool{}
+	for _, other := range claims.Items {
+		exists[other.UID] = true
+		if other.UID != claim.UID && other.Status.FloatingIP != "" {
+			used[other.Status.FloatingIP] = true
+		}
+	}
+	allocations := class.Status.Allocations[:0]
+	for _, allocation := range class.Status.Allocations {
+		if exists[allocation.ClaimRef.UID] {
+			allocations = append(allocations, allocation)
+			used[allocation.FloatingIP] = true
+		}
+	}
+	class.Status.Allocations = allocations
+
+	var bindings digitaloceanv1beta1.FloatingIPBindingList
+	if err := r.apiReader().List(ctx, &bindings); err != nil {
+		return nil, err
+	}
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if metav1.IsControlledBy(binding, claim) {
+			continue
+		}
+		used[binding.Spec.FloatingIP] = true
+		if binding.Status.ManagedIP != "" {
+			used[binding.Status.ManagedIP] = true
+		}
+	}
+	return used, nil
+}
+
+// apiReader returns the reader used for Secrets and for allocating floating IPs
+func (r *FloatingIPClaimReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// unrecordedIPs holds the floating IPs reserved for each class which haven't been
+// recorded in the class's status.provisionedIPs yet
+type unrecordedIPs struct {
+	mu  sync.Mutex
+	ips map[string][]string
+}
+
+func (u *unrecordedIPs) add(className string, ip string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.ips == nil {
+		u.ips = map[string][]string{}
+	}
+	u.ips[className] = append(u.ips[className], ip)
+}
+
+func (u *unrecordedIPs) get(className string) []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string{}, u.ips[className]...)
+}
+
+// remove forgets the floating IPs once they are recorded, keeping any reserved since
+func (u *unrecordedIPs) remove(className string, recorded []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	done := map[string]bool{}
+	for _, ip := range recorded {
+		done[ip] = true
+	}
+	remaining := u.ips[className][:0]
+	for _, ip := range u.ips[className] {
+		if !done[ip] {
+			remaining = append(remaining, ip)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(u.ips, className)
+	} else {
+		u.ips[className] = remaining
+	}
+}
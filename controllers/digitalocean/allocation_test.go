@@ -0,0 +1,117 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+func testClaim(name string, uid types.UID) *digitaloceanv1beta1.FloatingIPClaim {
+	return &digitaloceanv1beta1.FloatingIPClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", UID: uid},
+		Spec:       digitaloceanv1beta1.FloatingIPClaimSpec{ClassName: "pool"},
+	}
+}
+
+func newAllocationReconciler(t *testing.T, objs ...client.Object) *FloatingIPClaimReconciler {
+	scheme := runtime.NewScheme()
+	if err := digitaloceanv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return &FloatingIPClaimReconciler{Client: c, Scheme: scheme}
+}
+
+func TestAllocateIP(t *testing.T) {
+	ctx := context.Background()
+	class := &digitaloceanv1beta1.FloatingIPClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec:       digitaloceanv1beta1.FloatingIPClassSpec{FloatingIPs: []string{"203.0.113.1"}},
+	}
+	first := testClaim("first", "uid-first")
+	second := testClaim("second", "uid-second")
+	r := newAllocationReconciler(t, class, first, second)
+
+	ip, err := r.allocateIP(ctx, "pool", first)
+	if err != nil || ip != "203.0.113.1" {
+		t.Fatalf("allocateIP(first) = %q, %v, want 203.0.113.1", ip, err)
+	}
+	// The claim's status isn't updated yet, so only the class records the allocation
+	ip, err = r.allocateIP(ctx, "pool", second)
+	if err != nil || ip != "" {
+		t.Errorf("allocateIP(second) = %q, %v, want no floating IP available", ip, err)
+	}
+	ip, err = r.allocateIP(ctx, "pool", first)
+	if err != nil || ip != "203.0.113.1" {
+		t.Errorf("allocateIP(first) again = %q, %v, want the same floating IP", ip, err)
+	}
+
+	if err := r.Delete(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	ip, err = r.allocateIP(ctx, "pool", second)
+	if err != nil || ip != "203.0.113.1" {
+		t.Fatalf("allocateIP(second) after the first claim is deleted = %q, %v, want 203.0.113.1", ip, err)
+	}
+	latest := &digitaloceanv1beta1.FloatingIPClass{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pool"}, latest); err != nil {
+		t.Fatal(err)
+	}
+	if len(latest.Status.Allocations) != 1 || latest.Status.Allocations[0].ClaimRef.UID != second.UID {
+		t.Errorf("status.allocations = %+v, want only the second claim", latest.Status.Allocations)
+	}
+}
+
+func TestRecordProvisionedIPs(t *testing.T) {
+	ctx := context.Background()
+	r := newAllocationReconciler(t, &digitaloceanv1beta1.FloatingIPClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+	})
+
+	r.unrecorded.add("missing", "203.0.113.1")
+	if err := r.recordProvisionedIPs(ctx, ctrl.Log, "missing"); err == nil {
+		t.Error("recordProvisionedIPs() for a missing class succeeded, want an error")
+	}
+	if got := r.unrecorded.get("missing"); len(got) != 1 {
+		t.Errorf("unrecorded floating IPs after a failed write = %v, want them kept", got)
+	}
+
+	r.unrecorded.add("pool", "203.0.113.2")
+	if err := r.recordProvisionedIPs(ctx, ctrl.Log, "pool"); err != nil {
+		t.Fatalf("recordProvisionedIPs() error = %v", err)
+	}
+	if got := r.unrecorded.get("pool"); len(got) != 0 {
+		t.Errorf("unrecorded floating IPs after recording = %v, want none", got)
+	}
+	class := &digitaloceanv1beta1.FloatingIPClass{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pool"}, class); err != nil {
+		t.Fatal(err)
+	}
+	if len(class.Status.ProvisionedIPs) != 1 || class.Status.ProvisionedIPs[0] != "203.0.113.2" {
+		t.Errorf("status.provisionedIPs = %v, want [203.0.113.2]", class.Status.ProvisionedIPs)
+	}
+}
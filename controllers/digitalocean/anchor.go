@@ -0,0 +1,63 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// UpdateAnchor records the anchor network of droplet in the binding's status. The
+// droplet is only looked up when the floating IP has moved since the last update.
+func (r *FloatingIPBindingReconciler) UpdateAnchor(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	droplet *Droplet,
+) error {
+	if anchor := binding.Status.Anchor; anchor != nil && anchor.DropletID == droplet.ID {
+		return nil
+	}
+	binding.Status.Anchor = nil
+
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return err
+	}
+	details, err := doProvider.GetDroplet(ctx, droplet.ID)
+	if err != nil {
+		log.Error(err, "Failed to get droplet", "dropletID", droplet.ID)
+		return err
+	}
+	network, ok := provider.AnchorIPv4(details)
+	if !ok {
+		return fmt.Errorf("droplet %d has no anchor IP", droplet.ID)
+	}
+	binding.Status.Anchor = &digitaloceanv1beta1.Anchor{
+		DropletID: droplet.ID,
+		IPAddress: network.IPAddress,
+		Gateway:   network.Gateway,
+		Netmask:   network.Netmask,
+	}
+	return nil
+}
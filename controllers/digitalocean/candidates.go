@@ -0,0 +1,185 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// DefaultMaxCandidates is the number of nodes reported in status.candidates when
+// MaxCandidates isn't set
+const DefaultMaxCandidates = 10
+
+// candidate is an eligible droplet, either a node or a droplet selected by tag
+type candidate struct {
+	Droplet
+	Created metav1.Time
+}
+
+// SelectCandidate chooses a droplet from the eligible candidates, which must be
+// sorted by age, and records the candidates in the binding's status
+func (r *FloatingIPBindingReconciler) SelectCandidate(
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	eligible []candidate,
+	filtered []digitaloceanv1beta1.Candidate,
+) (*Droplet, error) {
+	if ReassignRequested(binding) {
+		// Exclude the current droplet so a manual reassignment always moves the IP
+		var others []candidate
+		var current []digitaloceanv1beta1.Candidate
+		for _, c := range eligible {
+			if c.ID != binding.Status.AssignedDropletID {
+				others = append(others, c)
+			} else {
+				current = append(current, statusCandidate(c, "Reassign"))
+			}
+		}
+		if len(others) > 0 {
+			eligible = others
+			filtered = append(filtered, current...)
+		} else {
+			log.Info("No other droplet to reassign to")
+		}
+	}
+
+	if len(eligible) == 0 {
+		log.Info("No nodes matching NodeSelector")
+		binding.Status.Candidates = r.rankCandidates(binding, nil, nil, filtered)
+		return nil, nil
+	}
+
+	// Choose droplet based on NodeSelectorPolicy
+	chosen, err := ChooseCandidate(log, binding, eligible)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep a manually reassigned droplet while the policy would move the IP straight back
+	// to the droplet it was moved from. The policy resumes once it chooses another droplet.
+	last := binding.Status.LastReassign
+	if !ReassignRequested(binding) && last != nil && last.To != nil && last.From != nil &&
+		last.To.ID == binding.Status.AssignedDropletID && chosen.ID == last.From.ID {
+		for i := range eligible {
+			if eligible[i].ID == last.To.ID {
+				chosen = &eligible[i]
+				log.Info("Manually reassigned droplet still exists. Skipping.")
+				break
+			}
+		}
+	}
+	binding.Status.Candidates = r.rankCandidates(binding, chosen, eligible, filtered)
+
+	droplet := chosen.Droplet
+	return &droplet, nil
+}
+
+// ChooseCandidate chooses a droplet from those sorted by age based on the NodeSelectorPolicy
+func ChooseCandidate(
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	eligible []candidate,
+) (*candidate, error) {
+	var chosen *candidate
+	switch binding.Spec.NodeSelectorPolicy {
+	case digitaloceanv1beta1.Newest:
+		// Select the last in the list
+		chosen = &eligible[len(eligible)-1]
+	case digitaloceanv1beta1.Oldest:
+		// Select the first in the list
+		chosen = &eligible[0]
+	case digitaloceanv1beta1.Random:
+		// If already randomly assigned select the same droplet
+		for i := range eligible {
+			if eligible[i].ID == binding.Status.AssignedDropletID {
+				chosen = &eligible[i]
+				log.Info("Randomly assigned droplet still exists. Skipping.")
+				break
+			}
+		}
+		if chosen == nil {
+			// If current droplet isn't found select a new one
+			i := rand.IntnRange(0, len(eligible))
+			chosen = &eligible[i]
+		}
+	default:
+		return nil, Permanent(fmt.Errorf("Invalid NodeSelectorPolicy: %s", binding.Spec.NodeSelectorPolicy))
+	}
+	return chosen, nil
+}
+
+// rankCandidates orders the eligible candidates by preference under the NodeSelectorPolicy,
+// with the chosen one first, followed by the filtered candidates. The list is truncated
+// to MaxCandidates.
+func (r *FloatingIPBindingReconciler) rankCandidates(
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	chosen *candidate,
+	eligible []candidate,
+	filtered []digitaloceanv1beta1.Candidate,
+) []digitaloceanv1beta1.Candidate {
+	limit := r.MaxCandidates
+	if limit <= 0 {
+		limit = DefaultMaxCandidates
+	}
+
+	// Order eligible candidates by preference
+	ranked := make([]candidate, 0, len(eligible))
+	if chosen != nil {
+		ranked = append(ranked, *chosen)
+	}
+	if binding.Spec.NodeSelectorPolicy == digitaloceanv1beta1.Newest {
+		for i := len(eligible) - 1; i >= 0; i-- {
+			if chosen == nil || eligible[i].ID != chosen.ID {
+				ranked = append(ranked, eligible[i])
+			}
+		}
+	} else {
+		for _, c := range eligible {
+			if chosen == nil || c.ID != chosen.ID {
+				ranked = append(ranked, c)
+			}
+		}
+	}
+
+	candidates := make([]digitaloceanv1beta1.Candidate, 0, len(ranked)+len(filtered))
+	for i, c := range ranked {
+		statusCandidate := statusCandidate(c, "")
+		statusCandidate.Score = len(ranked) - i
+		candidates = append(candidates, statusCandidate)
+	}
+	candidates = append(candidates, filtered...)
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+func statusCandidate(c candidate, filteredReason string) digitaloceanv1beta1.Candidate {
+	return digitaloceanv1beta1.Candidate{
+		Name:              c.Name,
+		DropletID:         c.ID,
+		CreationTimestamp: c.Created,
+		FilteredReason:    filteredReason,
+	}
+}
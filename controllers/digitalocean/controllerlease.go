@@ -0,0 +1,258 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+const (
+	// ControllerLeaseDuration is how long a controller instance is considered running
+	// after it last renewed its Lease
+	ControllerLeaseDuration = 90 * time.Second
+	// ControllerLeaseRenewInterval is how often a controller instance renews its Lease
+	ControllerLeaseRenewInterval = 30 * time.Second
+	// ControllerLeaseLabel marks the Leases of controller instances
+	ControllerLeaseLabel = "digitalocean.smirlwebs.com/controller-lease"
+	// ControllerNameAnnotation holds the controllerName of the instance holding a Lease
+	ControllerNameAnnotation = "digitalocean.smirlwebs.com/controller-name"
+	// WatchNamespacesAnnotation holds the comma separated namespaces watched by the
+	// instance holding a Lease. It is empty when the instance watches all namespaces.
+	WatchNamespacesAnnotation = "digitalocean.smirlwebs.com/watch-namespaces"
+)
+
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update
+
+// ControllerNameOf returns the controllerName of the instance which manages the binding
+func ControllerNameOf(binding *digitaloceanv1beta1.FloatingIPBinding) string {
+	if binding.Spec.ControllerName == "" {
+		return digitaloceanv1beta1.DefaultControllerName
+	}
+	return binding.Spec.ControllerName
+}
+
+// controllerName returns the controllerName of this instance
+func (r *FloatingIPBindingReconciler) controllerName() string {
+	if r.ControllerName == "" {
+		return digitaloceanv1beta1.DefaultControllerName
+	}
+	return r.ControllerName
+}
+
+// Claims returns true when the binding is managed by this controller instance
+func (r *FloatingIPBindingReconciler) Claims(binding *digitaloceanv1beta1.FloatingIPBinding) bool {
+	return ControllerNameOf(binding) == r.controllerName()
+}
+
+// ReportUnclaimed sets the Claimed condition to False on a binding which no running
+// controller instance manages. Bindings of running instances are left to them.
+func (r *FloatingIPBindingReconciler) ReportUnclaimed(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) (ctrl.Result, error) {
+	if r.Leases == nil {
+		return ctrl.Result{}, nil
+	}
+	// Check again later in case the instance managing the binding stops
+	result := ctrl.Result{RequeueAfter: r.requeueInterval()}
+
+	name := ControllerNameOf(binding)
+	managed, err := r.Leases.Manages(ctx, name, binding.Namespace)
+	if err != nil {
+		log.Error(err, "Failed to list controller leases")
+		return ctrl.Result{}, err
+	}
+	if managed {
+		return result, nil
+	}
+
+	condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionClaimed)
+	if condition != nil && condition.Status == metav1.ConditionFalse && condition.ObservedGeneration == binding.Generation {
+		return result, nil
+	}
+	message := fmt.Sprintf("No running controller instance with controllerName %s watches namespace %s",
+		name, binding.Namespace)
+	log.Info("Binding is not claimed by a running controller", "controllerName", name)
+	r.Recorder.Event(binding, v1.EventTypeWarning, "Unclaimed", message)
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionClaimed,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: binding.Generation,
+		Reason:             "NoController",
+		Message:            message,
+	})
+	if err := r.Status().Update(ctx, binding); err != nil {
+		log.Error(err, "Failed to update status")
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// ControllerLease renews a Lease advertising that a controller instance is running, so
+// that bindings no instance claims can be reported. It implements manager.Runnable and
+// runs on every replica, not only the leader, so a standby's Lease doesn't expire.
+type ControllerLease struct {
+	// Client writes the Lease
+	Client client.Client
+	// Reader reads Leases directly from the API server, as the Lease namespace may not be watched
+	Reader client.Reader
+	Log    logr.Logger
+	// Namespace holds the Leases of every controller instance. Instances only see the
+	// Leases of instances using the same Namespace.
+	Namespace string
+	// ControllerName is the controllerName of this instance
+	ControllerName string
+	// WatchNamespaces are the namespaces this instance manages bindings in. Empty means
+	// all namespaces.
+	WatchNamespaces []string
+	// Identity identifies this instance, e.g. the pod name
+	Identity string
+}
+
+// LeaseName returns the name of the Lease of the instances with the controllerName
+// watching the namespaces. Replicas of an instance share the Lease, while instances
+// with the same controllerName watching different namespaces each have their own.
+func LeaseName(controllerName string, watchNamespaces []string) string {
+	key := controllerName
+	if len(watchNamespaces) > 0 {
+		namespaces := append([]string{}, watchNamespaces...)
+		sort.Strings(namespaces)
+		key += "/" + strings.Join(namespaces, ",")
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "floating-ip-controller-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The Lease is renewed
+// whether or not this replica is the leader.
+func (l *ControllerLease) NeedLeaderElection() bool {
+	return false
+}
+
+// Start renews the Lease until the context is cancelled
+func (l *ControllerLease) Start(ctx context.Context) error {
+	ticker := time.NewTicker(ControllerLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		if err := l.renew(ctx); err != nil {
+			l.Log.Error(err, "Failed to renew controller lease")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *ControllerLease) renew(ctx context.Context) error {
+	key := types.NamespacedName{Namespace: l.Namespace, Name: LeaseName(l.ControllerName, l.WatchNamespaces)}
+	annotations := map[string]string{
+		ControllerNameAnnotation:  l.ControllerName,
+		WatchNamespacesAnnotation: strings.Join(l.WatchNamespaces, ","),
+	}
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(ControllerLeaseDuration.Seconds())
+
+	lease := &coordinationv1.Lease{}
+	err := l.Reader.Get(ctx, key, lease)
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        key.Name,
+				Namespace:   key.Namespace,
+				Labels:      map[string]string{ControllerLeaseLabel: "true"},
+				Annotations: annotations,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return l.Client.Create(ctx, lease)
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	for key, value := range annotations {
+		lease.Annotations[key] = value
+	}
+	lease.Spec.HolderIdentity = &l.Identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	return l.Client.Update(ctx, lease)
+}
+
+// Manages returns true when a running instance with the controllerName, which is an
+// instance with a current Lease, manages bindings in the namespace
+func (l *ControllerLease) Manages(ctx context.Context, controllerName string, namespace string) (bool, error) {
+	var leases coordinationv1.LeaseList
+	if err := l.Reader.List(ctx, &leases,
+		client.InNamespace(l.Namespace),
+		client.MatchingLabels{ControllerLeaseLabel: "true"},
+	); err != nil {
+		return false, err
+	}
+	for _, lease := range leases.Items {
+		if lease.Annotations[ControllerNameAnnotation] != controllerName || !leaseCurrent(&lease) {
+			continue
+		}
+		watched := lease.Annotations[WatchNamespacesAnnotation]
+		if watched == "" {
+			return true, nil
+		}
+		for _, watchedNamespace := range strings.Split(watched, ",") {
+			if watchedNamespace == namespace {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// leaseCurrent returns true when the Lease has been renewed within its duration
+func leaseCurrent(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expires := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().Before(expires)
+}
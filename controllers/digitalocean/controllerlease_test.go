@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testLease(controllerName string, watchNamespaces string, renewed time.Time) client.Object {
+	seconds := int32(ControllerLeaseDuration.Seconds())
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      LeaseName(controllerName, nil) + "-" + watchNamespaces,
+			Namespace: "leases",
+			Labels:    map[string]string{ControllerLeaseLabel: "true"},
+			Annotations: map[string]string{
+				ControllerNameAnnotation:  controllerName,
+				WatchNamespacesAnnotation: watchNamespaces,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{LeaseDurationSeconds: &seconds, RenewTime: &renewTime},
+	}
+}
+
+func TestControllerLeaseManages(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := coordinationv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		testLease("example.com/sharded", "team-a,team-b", now),
+		testLease("example.com/sharded", "team-c", now.Add(-2*ControllerLeaseDuration)),
+		testLease("example.com/everywhere", "", now),
+	).Build()
+	leases := &ControllerLease{Client: c, Reader: c, Namespace: "leases"}
+
+	tests := []struct {
+		controllerName string
+		namespace      string
+		want           bool
+	}{
+		{"example.com/sharded", "team-b", true},
+		{"example.com/sharded", "team-c", false},
+		{"example.com/sharded", "other", false},
+		{"example.com/everywhere", "other", true},
+		{"example.com/stopped", "team-a", false},
+	}
+	for _, tt := range tests {
+		got, err := leases.Manages(context.Background(), tt.controllerName, tt.namespace)
+		if err != nil {
+			t.Fatalf("Manages() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Manages(%q, %q) = %v, want %v", tt.controllerName, tt.namespace, got, tt.want)
+		}
+	}
+}
+
+func TestLeaseName(t *testing.T) {
+	if LeaseName("example.com/a", []string{"x", "y"}) != LeaseName("example.com/a", []string{"y", "x"}) {
+		t.Error("LeaseName() depends on the order of the namespaces")
+	}
+	if LeaseName("example.com/a", []string{"x"}) == LeaseName("example.com/a", []string{"y"}) {
+		t.Error("LeaseName() is the same for instances watching different namespaces")
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// DefaultCredentialsKey is the Secret key used when a CredentialsReference has no key
+const DefaultCredentialsKey = "DO_TOKEN"
+
+type cachedProvider struct {
+	token    string
+	provider provider.FloatingIPProvider
+}
+
+// ProviderCache builds and caches a provider for each credentials Secret
+type ProviderCache struct {
+	// NewProvider builds a provider from a token. Defaults to provider.NewGodoProvider
+	NewProvider func(token string) provider.FloatingIPProvider
+
+	mu        sync.Mutex
+	providers map[string]cachedProvider
+}
+
+// Get returns the cached provider for the Secret key, building a new one if the token has changed
+func (c *ProviderCache) Get(secret types.NamespacedName, key string, token string) provider.FloatingIPProvider {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cacheKey := secret.String() + "/" + key
+	if cached, ok := c.providers[cacheKey]; ok && cached.token == token {
+		return cached.provider
+	}
+
+	newProvider := c.NewProvider
+	if newProvider == nil {
+		newProvider = provider.NewGodoProvider
+	}
+	if c.providers == nil {
+		c.providers = map[string]cachedProvider{}
+	}
+	cached := cachedProvider{token: token, provider: newProvider(token)}
+	c.providers[cacheKey] = cached
+	return cached.provider
+}
+
+// Forget removes all cached providers for a Secret
+func (c *ProviderCache) Forget(secret types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for cacheKey := range c.providers {
+		if strings.HasPrefix(cacheKey, secret.String()+"/") {
+			delete(c.providers, cacheKey)
+		}
+	}
+}
+
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;watch;list
+
+// apiReader returns the reader used for Secrets and for checking the claims of class
+// bindings. Only Secret metadata is watched, so reading them through the cached Client
+// would cache every Secret in the cluster.
+func (r *FloatingIPBindingReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// ProviderFor returns the provider for a binding. This is built from the
+// binding's CredentialsRef or FloatingIPClass, or falls back to the global Provider.
+func (r *FloatingIPBindingReconciler) ProviderFor(
+	ctx context.Context,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) (provider.FloatingIPProvider, error) {
+	if binding.Spec.ClassName != "" {
+		return r.providerForClass(ctx, binding)
+	}
+	ref := binding.Spec.CredentialsRef
+	if ref == nil {
+		return r.Provider.Load(), nil
+	}
+	name := types.NamespacedName{Namespace: binding.Namespace, Name: ref.Name}
+	return providerFromSecret(ctx, r.apiReader(), &r.ProviderCache, name, ref.Key)
+}
+
+// providerForClass returns the provider of the binding's FloatingIPClass. Only a binding
+// created by a FloatingIPClaim of the class gets it, for the floating IP allocated to the
+// claim and with the class's nodeSelector, so a binding created or edited by hand can't
+// move the class's floating IPs with its credentials.
+func (r *FloatingIPBindingReconciler) providerForClass(
+	ctx context.Context,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) (provider.FloatingIPProvider, error) {
+	class := &digitaloceanv1beta1.FloatingIPClass{}
+	if err := r.apiReader().Get(ctx, types.NamespacedName{Name: binding.Spec.ClassName}, class); err != nil {
+		return nil, fmt.Errorf("could not get FloatingIPClass %s: %w", binding.Spec.ClassName, err)
+	}
+	if !class.AllowsNamespace(binding.Namespace) {
+		return nil, Permanent(fmt.Errorf("FloatingIPClass %s does not allow namespace %s", class.Name, binding.Namespace))
+	}
+	if err := r.checkClassBinding(ctx, class, binding); err != nil {
+		return nil, err
+	}
+	return classProvider(ctx, r.apiReader(), r.Provider, &r.ProviderCache, class)
+}
+
+// checkClassBinding returns a permanent error unless the binding is controlled by a
+// FloatingIPClaim of the class, its floating IP is the one allocated to the claim and its
+// nodeSelector is the one the claim controller sets
+func (r *FloatingIPBindingReconciler) checkClassBinding(
+	ctx context.Context,
+	class *digitaloceanv1beta1.FloatingIPClass,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	owner := metav1.GetControllerOf(binding)
+	if owner == nil || owner.Kind != "FloatingIPClaim" || owner.APIVersion != digitaloceanv1beta1.GroupVersion.String() {
+		return Permanent(fmt.Errorf("FloatingIPBinding %s is not controlled by a FloatingIPClaim of FloatingIPClass %s",
+			binding.Name, class.Name))
+	}
+	claim := &digitaloceanv1beta1.FloatingIPClaim{}
+	err := r.apiReader().Get(ctx, types.NamespacedName{Namespace: binding.Namespace, Name: owner.Name}, claim)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if apierrors.IsNotFound(err) || claim.UID != owner.UID {
+		// The claim is deleted before its binding is garbage collected, so the floating IP
+		// the controller assigned for it can still be released. A binding which never
+		// assigned its floating IP has nothing to release and only needs to be finalized.
+		if !binding.DeletionTimestamp.IsZero() &&
+			(binding.Status.ManagedIP == "" || binding.Spec.FloatingIP == binding.Status.ManagedIP) {
+			return nil
+		}
+		return Permanent(fmt.Errorf("FloatingIPClaim %s controlling FloatingIPBinding %s no longer exists",
+			owner.Name, binding.Name))
+	}
+	if claim.Spec.ClassName != class.Name {
+		return Permanent(fmt.Errorf("FloatingIPClaim %s is not of FloatingIPClass %s", claim.Name, class.Name))
+	}
+	allocated := class.Status.AllocationFor(claim.UID)
+	if allocated == "" && claim.Status.FloatingIP == binding.Spec.FloatingIP {
+		// A claim bound before allocations were recorded is recorded by the claim controller
+		return fmt.Errorf("floating IP %s is not recorded as allocated to FloatingIPClaim %s yet",
+			binding.Spec.FloatingIP, claim.Name)
+	}
+	if allocated == "" || allocated != binding.Spec.FloatingIP {
+		return Permanent(fmt.Errorf("floating IP %s is not allocated to FloatingIPClaim %s by FloatingIPClass %s",
+			binding.Spec.FloatingIP, claim.Name, class.Name))
+	}
+	selector, err := MergeSelectors(class.Spec.NodeSelector, claim.Spec.NodeSelector)
+	if err != nil {
+		return Permanent(err)
+	}
+	if !equality.Semantic.DeepEqual(selector, binding.Spec.NodeSelector) {
+		return Permanent(fmt.Errorf("nodeSelector of FloatingIPBinding %s doesn't match FloatingIPClaim %s and FloatingIPClass %s",
+			binding.Name, claim.Name, class.Name))
+	}
+	return nil
+}
+
+// classProvider returns the provider built from a FloatingIPClass's CredentialsRef, or
+// the global provider when it has none
+func classProvider(
+	ctx context.Context,
+	reader client.Reader,
+	global *ProviderHolder,
+	cache *ProviderCache,
+	class *digitaloceanv1beta1.FloatingIPClass,
+) (provider.FloatingIPProvider, error) {
+	ref := class.Spec.CredentialsRef
+	if ref == nil {
+		return global.Load(), nil
+	}
+	name := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+	return providerFromSecret(ctx, reader, cache, name, ref.Key)
+}
+
+// providerFromSecret returns the cached provider for the token in a Secret key
+func providerFromSecret(
+	ctx context.Context,
+	reader client.Reader,
+	cache *ProviderCache,
+	name types.NamespacedName,
+	key string,
+) (provider.FloatingIPProvider, error) {
+	if key == "" {
+		key = DefaultCredentialsKey
+	}
+	secret := &v1.Secret{}
+	if err := reader.Get(ctx, name, secret); err != nil {
+		return nil, fmt.Errorf("could not get credentials secret %s: %w", name, err)
+	}
+	token, ok := secret.Data[key]
+	if !ok || len(token) == 0 {
+		return nil, fmt.Errorf("credentials secret %s has no key %s", name, key)
+	}
+	return cache.Get(name, key, strings.TrimSpace(string(token))), nil
+}
+
+// credentialsSecret returns the Secret holding the binding's token, from its
+// CredentialsRef or FloatingIPClass, or nil when the global token is used
+func (r *FloatingIPBindingReconciler) credentialsSecret(
+	ctx context.Context,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) (*types.NamespacedName, error) {
+	if binding.Spec.ClassName != "" {
+		class := &digitaloceanv1beta1.FloatingIPClass{}
+		if err := r.Get(ctx, types.NamespacedName{Name: binding.Spec.ClassName}, class); err != nil {
+			return nil, fmt.Errorf("could not get FloatingIPClass %s: %w", binding.Spec.ClassName, err)
+		}
+		if ref := class.Spec.CredentialsRef; ref != nil {
+			return &types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, nil
+		}
+		return nil, nil
+	}
+	if ref := binding.Spec.CredentialsRef; ref != nil {
+		return &types.NamespacedName{Namespace: binding.Namespace, Name: ref.Name}, nil
+	}
+	return nil, nil
+}
+
+// credentialsVersion returns the resourceVersion of a credentials Secret, or an empty
+// string if it can't be read
+func (r *FloatingIPBindingReconciler) credentialsVersion(
+	ctx context.Context,
+	log logr.Logger,
+	name *types.NamespacedName,
+) string {
+	secret := &metav1.PartialObjectMetadata{}
+	secret.SetGroupVersionKind(v1.SchemeGroupVersion.WithKind("Secret"))
+	if err := r.apiReader().Get(ctx, *name, secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "Could not get credentials secret", "secret", name)
+		}
+		return ""
+	}
+	return secret.GetResourceVersion()
+}
+
+func (r *FloatingIPBindingReconciler) secretToRequests(secret client.Object) []reconcile.Request {
+	name := types.NamespacedName{Namespace: secret.GetNamespace(), Name: secret.GetName()}
+	// Drop any cached provider so a rotated or deleted token is never reused
+	r.ProviderCache.Forget(name)
+
+	// List bindings in the same namespace which reference the secret
+	var bindings digitaloceanv1beta1.FloatingIPBindingList
+	err := r.List(context.Background(), &bindings, client.InNamespace(secret.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list floating IP bindings")
+		return []reconcile.Request{}
+	}
+
+	var reconcileRequests []reconcile.Request
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if !r.Claims(binding) {
+			continue
+		}
+		if binding.Spec.CredentialsRef == nil || binding.Spec.CredentialsRef.Name != secret.GetName() {
+			continue
+		}
+		reconcileRequests = append(reconcileRequests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      binding.GetName(),
+				Namespace: binding.GetNamespace(),
+			},
+		})
+	}
+	return append(reconcileRequests, r.classSecretToRequests(name)...)
+}
+
+// classSecretToRequests returns the bindings of every FloatingIPClass using the secret
+// as its credentials
+func (r *FloatingIPBindingReconciler) classSecretToRequests(secret types.NamespacedName) []reconcile.Request {
+	var classes digitaloceanv1beta1.FloatingIPClassList
+	if err := r.List(context.Background(), &classes); err != nil {
+		r.Log.Error(err, "Failed to list floating IP classes")
+		return nil
+	}
+	classNames := map[string]bool{}
+	for _, class := range classes.Items {
+		ref := class.Spec.CredentialsRef
+		if ref != nil && ref.Namespace == secret.Namespace && ref.Name == secret.Name {
+			classNames[class.Name] = true
+		}
+	}
+	if len(classNames) == 0 {
+		return nil
+	}
+
+	var bindings digitaloceanv1beta1.FloatingIPBindingList
+	if err := r.List(context.Background(), &bindings); err != nil {
+		r.Log.Error(err, "Failed to list floating IP bindings")
+		return nil
+	}
+	var reconcileRequests []reconcile.Request
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if r.Claims(binding) && classNames[binding.Spec.ClassName] {
+			reconcileRequests = append(reconcileRequests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(binding),
+			})
+		}
+	}
+	return reconcileRequests
+}
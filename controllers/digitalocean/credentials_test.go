@@ -0,0 +1,152 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+func TestCheckClassBinding(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := digitaloceanv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	claim := &digitaloceanv1beta1.FloatingIPClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a", UID: "uid-web"},
+		Spec: digitaloceanv1beta1.FloatingIPClaimSpec{
+			ClassName:    "pool",
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+		Status: digitaloceanv1beta1.FloatingIPClaimStatus{FloatingIP: "203.0.113.1"},
+	}
+	class := &digitaloceanv1beta1.FloatingIPClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec: digitaloceanv1beta1.FloatingIPClassSpec{
+			FloatingIPs:  []string{"203.0.113.1", "203.0.113.2"},
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "ingress"}},
+		},
+		Status: digitaloceanv1beta1.FloatingIPClassStatus{
+			Allocations: []digitaloceanv1beta1.FloatingIPAllocation{{
+				FloatingIP: "203.0.113.1",
+				ClaimRef:   digitaloceanv1beta1.ClaimReference{Namespace: "team-a", Name: "web", UID: "uid-web"},
+			}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(claim).Build()
+	r := &FloatingIPBindingReconciler{Client: c}
+
+	owned := func() *digitaloceanv1beta1.FloatingIPBinding {
+		selector, err := MergeSelectors(class.Spec.NodeSelector, claim.Spec.NodeSelector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binding := &digitaloceanv1beta1.FloatingIPBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a"},
+			Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+				FloatingIP:   "203.0.113.1",
+				ClassName:    "pool",
+				NodeSelector: selector,
+			},
+		}
+		controller := true
+		binding.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: digitaloceanv1beta1.GroupVersion.String(),
+			Kind:       "FloatingIPClaim",
+			Name:       claim.Name,
+			UID:        claim.UID,
+			Controller: &controller,
+		}}
+		return binding
+	}
+
+	tests := []struct {
+		name      string
+		binding   func() *digitaloceanv1beta1.FloatingIPBinding
+		permanent bool
+	}{
+		{"created by the claim", owned, false},
+		{"created by hand", func() *digitaloceanv1beta1.FloatingIPBinding {
+			binding := owned()
+			binding.OwnerReferences = nil
+			return binding
+		}, true},
+		{"another floating IP of the class", func() *digitaloceanv1beta1.FloatingIPBinding {
+			binding := owned()
+			binding.Spec.FloatingIP = "203.0.113.2"
+			return binding
+		}, true},
+		{"class nodeSelector removed", func() *digitaloceanv1beta1.FloatingIPBinding {
+			binding := owned()
+			binding.Spec.NodeSelector = claim.Spec.NodeSelector.DeepCopy()
+			return binding
+		}, true},
+		{"claim recreated", func() *digitaloceanv1beta1.FloatingIPBinding {
+			binding := owned()
+			binding.OwnerReferences[0].UID = "uid-old"
+			return binding
+		}, true},
+		{"deleted after the claim was recreated", func() *digitaloceanv1beta1.FloatingIPBinding {
+			binding := owned()
+			binding.OwnerReferences[0].UID = "uid-old"
+			binding.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			binding.Status.ManagedIP = "203.0.113.1"
+			return binding
+		}, false},
+		{"deleted before it assigned its floating IP", func() *digitaloceanv1beta1.FloatingIPBinding {
+			binding := owned()
+			binding.OwnerReferences[0].UID = "uid-old"
+			binding.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			return binding
+		}, false},
+		{"deleted after its floating IP was changed", func() *digitaloceanv1beta1.FloatingIPBinding {
+			binding := owned()
+			binding.OwnerReferences[0].UID = "uid-old"
+			binding.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			binding.Spec.FloatingIP = "203.0.113.2"
+			binding.Status.ManagedIP = "203.0.113.1"
+			return binding
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.checkClassBinding(context.Background(), class, tt.binding())
+			if tt.permanent && ClassifyError(err) != ErrorPermanent {
+				t.Errorf("checkClassBinding() = %v, want a permanent error", err)
+			}
+			if !tt.permanent && err != nil {
+				t.Errorf("checkClassBinding() = %v, want nil", err)
+			}
+		})
+	}
+
+	t.Run("claim bound before allocations were recorded", func(t *testing.T) {
+		unrecorded := class.DeepCopy()
+		unrecorded.Status.Allocations = nil
+		err := r.checkClassBinding(context.Background(), unrecorded, owned())
+		if err == nil || ClassifyError(err) == ErrorPermanent {
+			t.Errorf("checkClassBinding() = %v, want a transient error", err)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// EnsureFinalizer adds the Finalizer to bindings with the Release DeletionPolicy or a
+// published Service, and removes it from any others
+func (r *FloatingIPBindingReconciler) EnsureFinalizer(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	needed := binding.Spec.DeletionPolicy == digitaloceanv1beta1.Release ||
+		binding.Spec.ServiceRef != nil || binding.Status.Service != nil
+	if needed == controllerutil.ContainsFinalizer(binding, digitaloceanv1beta1.Finalizer) {
+		return nil
+	}
+	if needed {
+		controllerutil.AddFinalizer(binding, digitaloceanv1beta1.Finalizer)
+	} else {
+		controllerutil.RemoveFinalizer(binding, digitaloceanv1beta1.Finalizer)
+	}
+	if err := r.Update(ctx, binding); err != nil {
+		log.Error(err, "Failed to update finalizers")
+		return err
+	}
+	return nil
+}
+
+// Finalize removes the floating IP from the published Service and, for the Release
+// DeletionPolicy, releases the floating IP, DNS record and firewall membership of a
+// binding being deleted, then removes the Finalizer
+func (r *FloatingIPBindingReconciler) Finalize(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(binding, digitaloceanv1beta1.Finalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// The Service can't keep listing a floating IP which is no longer managed
+	if err := r.UnpublishService(ctx, log, binding); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	switch {
+	case binding.Spec.DeletionPolicy != digitaloceanv1beta1.Release:
+		// Retain leaves the floating IP, DNS record and firewall as they are
+	case r.DryRunEnabled(binding):
+		log.Info("Dry-run enabled. Skipping release.")
+		r.Recorder.Event(binding, v1.EventTypeNormal, "DryRun",
+			"Would release floating IP, DNS record and firewall membership as the binding is deleted")
+	default:
+		// Only unassign the floating IP from the droplet this binding assigned it to
+		log.Info("Releasing floating IP")
+		err := r.unassignIP(ctx, log, binding, binding.Spec.FloatingIP, assignedByBinding(binding))
+		// Release the previous floating IP too if spec.floatingIP changed and hasn't been reconciled
+		if err == nil && ManagedIPChanged(binding) {
+			err = r.unassignIP(ctx, log, binding, binding.Status.ManagedIP, assignedByBinding(binding))
+		}
+		var conflict *TakeoverConflictError
+		if errors.As(err, &conflict) {
+			log.Info("Leaving floatingIP assigned to a droplet this binding didn't assign it to",
+				"currentDropletID", conflict.DropletID, "assignedDropletID", binding.Status.AssignedDropletID)
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.DeleteDNSRecord(ctx, log, binding); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.RemoveFromFirewall(ctx, log, binding); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.UnlabelNodes(ctx, log, client.ObjectKeyFromObject(binding)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(binding, digitaloceanv1beta1.Finalizer)
+	if err := r.Update(ctx, binding); err != nil {
+		log.Error(err, "Failed to remove finalizer")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
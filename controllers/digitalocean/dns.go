@@ -0,0 +1,168 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+
+	"github.com/digitalocean/godo"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// DefaultDNSTTL is the TTL of DNS records without one
+const DefaultDNSTTL = 1800
+
+// SyncDNSRecord creates or repairs the binding's A record so it points at the floating IP,
+// and deletes the previously managed record when spec.dns changes
+func (r *FloatingIPBindingReconciler) SyncDNSRecord(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	dns := binding.Spec.DNS
+	current := binding.Status.DNSRecord
+	if current != nil && (dns == nil || current.Domain != dns.Domain || current.Name != dns.Name) {
+		if err := r.DeleteDNSRecord(ctx, log, binding); err != nil {
+			return err
+		}
+	}
+	if dns == nil {
+		meta.RemoveStatusCondition(&binding.Status.Conditions, digitaloceanv1beta1.ConditionDNSReady)
+		return nil
+	}
+
+	err := r.syncDNSRecord(ctx, log, binding)
+	if err != nil {
+		r.Recorder.Event(binding, v1.EventTypeWarning, "DNSSyncFailed", err.Error())
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionDNSReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: binding.Generation,
+			Reason:             "SyncFailed",
+			Message:            err.Error(),
+		})
+		return err
+	}
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionDNSReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: binding.Generation,
+		Reason:             "Synced",
+		Message:            "DNS record points at the floating IP",
+	})
+	return nil
+}
+
+func (r *FloatingIPBindingReconciler) syncDNSRecord(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	dns := binding.Spec.DNS
+	log = log.WithValues("domain", dns.Domain, "record", dns.Name)
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return err
+	}
+	records, err := doProvider.ListDomainRecords(ctx, dns.Domain)
+	if err != nil {
+		log.Error(err, "Failed to list DNS records")
+		return err
+	}
+
+	// Prefer the record created for the binding over any other with the same name
+	var record *godo.DomainRecord
+	for i := range records {
+		if records[i].Type != "A" || records[i].Name != dns.Name {
+			continue
+		}
+		if record == nil || (binding.Status.DNSRecord != nil && records[i].ID == binding.Status.DNSRecord.ID) {
+			record = &records[i]
+		}
+	}
+
+	ttl := dns.TTL
+	if ttl == 0 {
+		ttl = DefaultDNSTTL
+	}
+	req := &godo.DomainRecordEditRequest{
+		Type: "A",
+		Name: dns.Name,
+		Data: binding.Spec.FloatingIP,
+		TTL:  ttl,
+	}
+	switch {
+	case record == nil:
+		record, err = doProvider.CreateDomainRecord(ctx, dns.Domain, req)
+		if err != nil {
+			log.Error(err, "Failed to create DNS record")
+			return err
+		}
+		log.Info("Created DNS record", "recordID", record.ID)
+		r.Recorder.Eventf(binding, v1.EventTypeNormal, "DNSRecordCreated",
+			"Created A record %s in %s", dns.Name, dns.Domain)
+	case record.Data != req.Data || record.TTL != req.TTL:
+		log.Info("DNS record has drifted. Repairing.", "recordID", record.ID, "data", record.Data, "ttl", record.TTL)
+		record, err = doProvider.EditDomainRecord(ctx, dns.Domain, record.ID, req)
+		if err != nil {
+			log.Error(err, "Failed to update DNS record")
+			return err
+		}
+		r.Recorder.Eventf(binding, v1.EventTypeNormal, "DNSRecordUpdated",
+			"Updated A record %s in %s to point at %s", dns.Name, dns.Domain, binding.Spec.FloatingIP)
+	}
+
+	binding.Status.DNSRecord = &digitaloceanv1beta1.DNSRecordReference{
+		Domain: dns.Domain,
+		Name:   dns.Name,
+		ID:     record.ID,
+	}
+	return nil
+}
+
+// DeleteDNSRecord deletes the DNS record managed for the binding
+func (r *FloatingIPBindingReconciler) DeleteDNSRecord(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	current := binding.Status.DNSRecord
+	if current == nil {
+		return nil
+	}
+	log = log.WithValues("domain", current.Domain, "record", current.Name, "recordID", current.ID)
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return err
+	}
+	err = doProvider.DeleteDomainRecord(ctx, current.Domain, current.ID)
+	if err != nil && !provider.IsNotFound(err) {
+		log.Error(err, "Failed to delete DNS record")
+		return err
+	}
+	log.Info("Deleted DNS record")
+	binding.Status.DNSRecord = nil
+	return nil
+}
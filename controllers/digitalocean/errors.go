@@ -0,0 +1,227 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+const (
+	// BaseRetryDelay is how long to wait before retrying after the first transient error
+	// when RetryBaseDelay isn't set
+	BaseRetryDelay = 5 * time.Second
+	// MaxRetryDelay caps how long to wait before retrying after repeated transient errors
+	// when the reconciler's MaxRetryDelay isn't set
+	MaxRetryDelay = 5 * time.Minute
+	// RetryJitter is the fraction of the delay added at random so retries are spread out
+	RetryJitter = 0.2
+)
+
+// ErrorClass describes how a reconcile error is retried
+type ErrorClass string
+
+const (
+	// ErrorTransient errors, such as timeouts or 5xx responses, are retried with backoff
+	ErrorTransient ErrorClass = "Transient"
+	// ErrorPermanent errors, such as an invalid spec or a 404 on the floating IP, won't
+	// be fixed by retrying. The binding is parked until its spec changes.
+	ErrorPermanent ErrorClass = "Permanent"
+	// ErrorConflict errors happen when the binding was updated concurrently. They are
+	// retried straight away with the latest version.
+	ErrorConflict ErrorClass = "Conflict"
+)
+
+// PermanentError marks an error which retrying won't fix
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as an error which retrying won't fix
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// TransientError marks an error which is retried with backoff whatever its cause
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// Transient marks err to be retried with backoff, e.g. when the floating IP has already
+// been assigned so the binding mustn't be parked
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TransientError{Err: err}
+}
+
+// ClassifyError returns how err should be retried
+func ClassifyError(err error) ErrorClass {
+	var transient *TransientError
+	if errors.As(err, &transient) {
+		return ErrorTransient
+	}
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return ErrorPermanent
+	}
+	if apierrors.IsConflict(err) {
+		return ErrorConflict
+	}
+	var statusErr *provider.StatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests,
+			statusErr.StatusCode == http.StatusUnprocessableEntity,
+			statusErr.StatusCode >= http.StatusInternalServerError:
+			return ErrorTransient
+		case statusErr.StatusCode == http.StatusBadRequest,
+			statusErr.StatusCode == http.StatusUnauthorized,
+			statusErr.StatusCode == http.StatusForbidden:
+			// The request or the token is wrong
+			return ErrorPermanent
+		}
+	}
+	return ErrorTransient
+}
+
+// RetryDelay returns how long to wait before retrying after failures consecutive
+// transient errors. The delay doubles with each failure up to the maximum.
+func (r *FloatingIPBindingReconciler) RetryDelay(failures int) time.Duration {
+	delay, maxDelay := r.RetryBaseDelay, r.MaxRetryDelay
+	if delay <= 0 {
+		delay = BaseRetryDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = MaxRetryDelay
+	}
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return wait.Jitter(delay, RetryJitter)
+}
+
+// errParked is returned by reconcile for a parked binding, so its failure count is kept
+var errParked = errors.New("binding is parked by a permanent error")
+
+// Parked returns true when the binding was parked by a permanent error and its spec
+// hasn't changed since. Requesting a reassignment retries a parked binding, as does a
+// change to its credentials Secret or reloading the global token for bindings using it.
+func (r *FloatingIPBindingReconciler) Parked(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) bool {
+	condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionStalled)
+	if condition == nil || condition.Status != metav1.ConditionTrue {
+		return false
+	}
+	if condition.ObservedGeneration != binding.Generation || ReassignRequested(binding) {
+		return false
+	}
+	secret, err := r.credentialsSecret(ctx, binding)
+	if err != nil {
+		// The FloatingIPClass may have been fixed, so retry
+		log.Info("Could not find the credentials of a parked binding. Retrying.", "error", err.Error())
+		return false
+	}
+	if secret == nil {
+		return r.Provider == nil || !r.Provider.StoredAt().After(condition.LastTransitionTime.Time)
+	}
+	return r.credentialsVersion(ctx, log, secret) == binding.Status.StalledCredentialsVersion
+}
+
+// Park records a permanent error in the Stalled condition so the binding isn't
+// retried until its spec changes
+func (r *FloatingIPBindingReconciler) Park(
+	ctx context.Context,
+	log logr.Logger,
+	name types.NamespacedName,
+	reconcileErr error,
+) error {
+	binding, err := r.GetFloatingIPBinding(ctx, log, name)
+	if err != nil || binding == nil {
+		return err
+	}
+	log.Error(reconcileErr, "Permanent error. Parking binding until its spec changes.")
+	alreadyParked := meta.IsStatusConditionTrue(binding.Status.Conditions, digitaloceanv1beta1.ConditionStalled)
+	binding.Status.StalledCredentialsVersion = ""
+	if secret, err := r.credentialsSecret(ctx, binding); err == nil && secret != nil {
+		binding.Status.StalledCredentialsVersion = r.credentialsVersion(ctx, log, secret)
+	}
+	r.Recorder.Event(binding, v1.EventTypeWarning, "Stalled", reconcileErr.Error())
+	// Replace rather than update the condition so that the time it was parked is current
+	meta.RemoveStatusCondition(&binding.Status.Conditions, digitaloceanv1beta1.ConditionStalled)
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionStalled,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: binding.Generation,
+		Reason:             "PermanentError",
+		Message:            reconcileErr.Error(),
+	})
+	if err := r.Status().Update(ctx, binding); err != nil {
+		log.Error(err, "Failed to update status")
+		return err
+	}
+	// A parked binding isn't retried, so report it now rather than after
+	// FailureNotifyThreshold failures
+	if !alreadyParked {
+		r.Notify(ctx, log, binding, Notification{
+			Event:      digitaloceanv1beta1.NotifyFailed,
+			OldDroplet: statusDroplet(binding),
+			Reason:     "Stalled",
+			Message:    reconcileErr.Error(),
+		})
+	}
+	return nil
+}
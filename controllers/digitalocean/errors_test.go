@@ -0,0 +1,50 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+func TestClassifyError(t *testing.T) {
+	unauthorized := &provider.StatusError{StatusCode: http.StatusUnauthorized, Message: "Unable to authenticate you."}
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"rate limited", &provider.StatusError{StatusCode: http.StatusTooManyRequests}, ErrorTransient},
+		{"server error", &provider.StatusError{StatusCode: http.StatusBadGateway}, ErrorTransient},
+		{"rejected token", unauthorized, ErrorPermanent},
+		{"wrapped rejected token", fmt.Errorf("assigning: %w", unauthorized), ErrorPermanent},
+		{"permanent", Permanent(errors.New("invalid spec")), ErrorPermanent},
+		{"transient wins over a permanent cause", Transient(Permanent(errors.New("node label key"))), ErrorTransient},
+		{"unknown", errors.New("connection reset"), ErrorTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
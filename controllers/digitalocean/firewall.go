@@ -0,0 +1,212 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// SyncFirewall adds droplet to the binding's Cloud Firewall, then removes the droplet
+// previously added for the binding
+func (r *FloatingIPBindingReconciler) SyncFirewall(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	droplet *Droplet,
+) error {
+	firewallID := binding.Spec.FirewallID
+	if firewallID == "" {
+		meta.RemoveStatusCondition(&binding.Status.Conditions, digitaloceanv1beta1.ConditionFirewallReady)
+		return r.RemoveFromFirewall(ctx, log, binding)
+	}
+
+	membership := digitaloceanv1beta1.FirewallMembership{ID: firewallID, DropletID: droplet.ID}
+	added, err := r.addToFirewall(ctx, log, binding, droplet)
+	if err == nil && !added {
+		// A droplet added by another binding is removed by whichever binding needs it last
+		added, err = r.firewallMembershipRecorded(ctx, binding, membership)
+	}
+	if err == nil {
+		if current := binding.Status.Firewall; current != nil && *current != membership {
+			err = r.RemoveFromFirewall(ctx, log, binding)
+		}
+	}
+	if err != nil {
+		r.Recorder.Event(binding, v1.EventTypeWarning, "FirewallSyncFailed", err.Error())
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionFirewallReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: binding.Generation,
+			Reason:             "SyncFailed",
+			Message:            err.Error(),
+		})
+		return err
+	}
+
+	// Droplets which were already in the firewall are left there
+	if added {
+		binding.Status.Firewall = &membership
+	}
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionFirewallReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: binding.Generation,
+		Reason:             "Synced",
+		Message:            fmt.Sprintf("Droplet %s (%d) is in firewall %s", droplet.Name, droplet.ID, firewallID),
+	})
+	return nil
+}
+
+// addToFirewall adds droplet to the binding's Cloud Firewall. Returns true if the droplet
+// was added rather than already in the firewall.
+func (r *FloatingIPBindingReconciler) addToFirewall(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	droplet *Droplet,
+) (bool, error) {
+	firewallID := binding.Spec.FirewallID
+	log = log.WithValues("firewallID", firewallID, "dropletID", droplet.ID)
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return false, err
+	}
+	firewall, err := doProvider.GetFirewall(ctx, firewallID)
+	if err != nil {
+		log.Error(err, "Failed to get firewall")
+		return false, err
+	}
+	for _, dropletID := range firewall.DropletIDs {
+		if dropletID == droplet.ID {
+			return false, nil
+		}
+	}
+	if err := doProvider.AddDropletsToFirewall(ctx, firewallID, droplet.ID); err != nil {
+		log.Error(err, "Failed to add droplet to firewall")
+		return false, err
+	}
+	log.Info("Added droplet to firewall")
+	r.Recorder.Eventf(binding, v1.EventTypeNormal, "FirewallUpdated",
+		"Added droplet %s (%d) to firewall %s", droplet.Name, droplet.ID, firewallID)
+	return true, nil
+}
+
+// firewallMembershipRecorded returns true if another binding recorded adding the droplet
+// to the firewall
+func (r *FloatingIPBindingReconciler) firewallMembershipRecorded(
+	ctx context.Context,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	membership digitaloceanv1beta1.FirewallMembership,
+) (bool, error) {
+	others, err := r.otherBindings(ctx, binding)
+	if err != nil {
+		return false, err
+	}
+	for _, other := range others {
+		if other.Status.Firewall != nil && *other.Status.Firewall == membership {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// firewallMembershipNeeded returns true if another binding keeps the droplet holding its
+// floating IP in the same firewall
+func (r *FloatingIPBindingReconciler) firewallMembershipNeeded(
+	ctx context.Context,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	membership digitaloceanv1beta1.FirewallMembership,
+) (bool, error) {
+	others, err := r.otherBindings(ctx, binding)
+	if err != nil {
+		return false, err
+	}
+	for _, other := range others {
+		if other.DeletionTimestamp.IsZero() && other.Spec.FirewallID == membership.ID &&
+			other.Status.AssignedDropletID == membership.DropletID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// otherBindings lists every binding except binding
+func (r *FloatingIPBindingReconciler) otherBindings(
+	ctx context.Context,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) ([]digitaloceanv1beta1.FloatingIPBinding, error) {
+	var bindings digitaloceanv1beta1.FloatingIPBindingList
+	if err := r.List(ctx, &bindings); err != nil {
+		return nil, fmt.Errorf("could not list floating IP bindings: %w", err)
+	}
+	var others []digitaloceanv1beta1.FloatingIPBinding
+	for _, other := range bindings.Items {
+		if other.UID != binding.UID {
+			others = append(others, other)
+		}
+	}
+	return others, nil
+}
+
+// RemoveFromFirewall removes the droplet previously added for the binding from its
+// Cloud Firewall, unless another binding still needs it there
+func (r *FloatingIPBindingReconciler) RemoveFromFirewall(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	current := binding.Status.Firewall
+	if current == nil {
+		return nil
+	}
+	log = log.WithValues("firewallID", current.ID, "dropletID", current.DropletID)
+	needed, err := r.firewallMembershipNeeded(ctx, binding, *current)
+	if err != nil {
+		log.Error(err, "Failed to check other bindings using the firewall")
+		return err
+	}
+	if needed {
+		log.Info("Another binding needs the droplet in the firewall. Leaving it.")
+		binding.Status.Firewall = nil
+		return nil
+	}
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return err
+	}
+	err = doProvider.RemoveDropletsFromFirewall(ctx, current.ID, current.DropletID)
+	if err != nil && !provider.IsNotFound(err) {
+		log.Error(err, "Failed to remove droplet from firewall")
+		return err
+	}
+	log.Info("Removed droplet from firewall")
+	r.Recorder.Eventf(binding, v1.EventTypeNormal, "FirewallUpdated",
+		"Removed droplet %d from firewall %s", current.DropletID, current.ID)
+	binding.Status.Firewall = nil
+	return nil
+}
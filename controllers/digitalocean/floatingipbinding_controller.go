@@ -18,53 +18,130 @@ package digitalocean
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/digitalocean/godo"
 	"github.com/go-logr/logr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/notify"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
 )
 
+// RequeueAfter is how often bindings are reconciled again when RequeueInterval isn't set
 const RequeueAfter = time.Minute * 5
 
 // Hold information about a droplet
 type Droplet struct {
 	ID   int
 	Name string
+	// The node backed by the droplet. Empty for droplets selected by tag
+	Node string
 }
 
+// TakeoverConflictError is returned when the TakeoverPolicy forbids moving the
+// floating IP from the droplet it is currently assigned to
+type TakeoverConflictError struct {
+	FloatingIP string
+	DropletID  int
+	Policy     digitaloceanv1beta1.TakeoverPolicy
+}
+
+func (e *TakeoverConflictError) Error() string {
+	return fmt.Sprintf(
+		"floating IP %s is assigned to droplet %d which TakeoverPolicy %s does not allow taking over",
+		e.FloatingIP, e.DropletID, e.Policy,
+	)
+}
+
+// ErrFrozen is returned when the floating IP would be moved while the controller is frozen
+var ErrFrozen = errors.New("controller is frozen, floating IPs which are already assigned are not moved")
+
 // FloatingIPBindingReconciler reconciles a FloatingIPBinding object
 type FloatingIPBindingReconciler struct {
 	client.Client
-	Log                logr.Logger
-	Scheme             *runtime.Scheme
-	DigitaloceanClient *godo.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Provider is used for bindings without a CredentialsRef
+	Provider *ProviderHolder
+	Recorder record.EventRecorder
+	// ProviderCache holds providers built from each binding's CredentialsRef
+	ProviderCache ProviderCache
+	// DropletCache holds the droplets used to resolve nodes without a providerID
+	DropletCache DropletCache
+	// DropletCacheTTL is how long listed droplets are cached. Defaults to DropletCacheTTL
+	DropletCacheTTL time.Duration
+	// APIReader reads Secrets straight from the API server so they aren't cached, and the
+	// classes and claims of class bindings so a fresh allocation is seen. Defaults to the Client
+	APIReader client.Reader
+	// DryRun stops all bindings from assigning or unassigning floating IPs
+	DryRun bool
+	// MaxCandidates limits the number of nodes reported in status.candidates.
+	// Defaults to DefaultMaxCandidates
+	MaxCandidates int
+	// Notifier sends webhook notifications. Notifications are disabled when nil
+	Notifier *notify.Dispatcher
+	// RequeueInterval is how often bindings are reconciled again. Defaults to RequeueAfter
+	RequeueInterval time.Duration
+	// TagPollInterval is how often bindings targeting a DropletTag are reconciled.
+	// Defaults to DropletTagPollInterval
+	TagPollInterval time.Duration
+	// MaxConcurrentReconciles is how many bindings are reconciled at once. Defaults to 1
+	MaxConcurrentReconciles int
+	// Freeze stops floating IPs which are already assigned from being moved or unassigned
+	Freeze bool
+	// RetryBaseDelay is how long to wait before retrying after a transient error.
+	// Defaults to BaseRetryDelay
+	RetryBaseDelay time.Duration
+	// MaxRetryDelay caps the backoff after repeated transient errors. Defaults to MaxRetryDelay
+	MaxRetryDelay time.Duration
+	// ControllerName selects the bindings managed by this instance.
+	// Defaults to DefaultControllerName
+	ControllerName string
+	// Leases reports bindings which no running instance claims. Reporting is disabled when nil
+	Leases *ControllerLease
+
+	failuresMu sync.Mutex
+	failures   map[types.NamespacedName]int
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *FloatingIPBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		For(&digitaloceanv1beta1.FloatingIPBinding{}).
 		Watches(
 			&source.Kind{Type: &v1.Node{}},
 			handler.EnqueueRequestsFromMapFunc(r.nodeToRequests),
+			builder.WithPredicates(ignoreOwnNodeMarks),
+		).
+		Watches(
+			&source.Kind{Type: &v1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.secretToRequests),
+			builder.OnlyMetadata,
+		).
+		Watches(
+			&source.Kind{Type: &v1.Service{}},
+			handler.EnqueueRequestsFromMapFunc(r.serviceToRequests),
 		).
 		Complete(r)
 }
@@ -72,47 +149,439 @@ func (r *FloatingIPBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
 //+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipbindings,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipbindings/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipbindings/finalizers,verbs=update
-//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;watch;list
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;watch;list;patch;update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *FloatingIPBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("floatingipbinding", req.NamespacedName)
+	result, err := r.reconcile(ctx, log, req)
+	if errors.Is(err, errParked) {
+		// Keep counting the failures of a binding which is still parked
+		return ctrl.Result{}, nil
+	}
+	if err == nil {
+		r.TrackFailure(ctx, log, req.NamespacedName, nil)
+		return result, nil
+	}
+
+	// Retry according to the kind of error rather than controller-runtime's rate limiter
+	switch ClassifyError(err) {
+	case ErrorConflict:
+		log.Info("Binding was modified. Retrying.", "error", err.Error())
+		return ctrl.Result{Requeue: true}, nil
+	case ErrorPermanent:
+		r.TrackFailure(ctx, log, req.NamespacedName, err)
+		return ctrl.Result{}, r.Park(ctx, log, req.NamespacedName, err)
+	default:
+		failures := r.TrackFailure(ctx, log, req.NamespacedName, err)
+		delay := r.RetryDelay(failures)
+		log.Error(err, "Reconcile failed. Retrying.", "retryAfter", delay.String())
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+}
+
+func (r *FloatingIPBindingReconciler) reconcile(
+	ctx context.Context,
+	log logr.Logger,
+	req ctrl.Request,
+) (ctrl.Result, error) {
 
 	// Get the FloatingIPBinding from Kubernetes
 	binding, err := r.GetFloatingIPBinding(ctx, log, req.NamespacedName)
 	if err != nil {
-		return ctrl.Result{RequeueAfter: RequeueAfter}, err
+		return ctrl.Result{}, err
+	}
+
+	if binding == nil {
+		// Remove the node labels of a deleted binding
+		if err := r.UnlabelNodes(ctx, log, req.NamespacedName); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Leave bindings of other controller instances to them
+	if !r.Claims(binding) {
+		return r.ReportUnclaimed(ctx, log, binding)
+	}
+
+	// Apply the DeletionPolicy
+	if !binding.DeletionTimestamp.IsZero() {
+		return r.Finalize(ctx, log, binding)
+	}
+	if err := r.EnsureFinalizer(ctx, log, binding); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Don't retry permanent errors until the spec changes
+	if r.Parked(ctx, log, binding) {
+		log.Info("Binding is stalled by a permanent error. Skipping until its spec changes.")
+		return ctrl.Result{}, errParked
+	}
+
+	// Check the node label key before anything is assigned, so an invalid key parks the
+	// binding before the floating IP moves rather than after
+	if _, err := NodeLabelKey(client.ObjectKeyFromObject(binding)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Let go of the previous floating IP when spec.floatingIP has changed
+	if err := r.ReleaseManagedIP(ctx, log, binding); errors.Is(err, ErrFrozen) {
+		log.Info("Controller is frozen. Not releasing previous floatingIP.")
+		r.Recorder.Event(binding, v1.EventTypeNormal, "Frozen",
+			"Controller is frozen, not releasing the previous floating IP")
+		return ctrl.Result{RequeueAfter: r.requeueInterval()}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Droplets selected by tag have no watch, so poll them instead
+	result := ctrl.Result{}
+	if TargetsDropletTag(binding) {
+		result.RequeueAfter = r.tagPollInterval()
 	}
 
 	// Get the best node/droplet to assign to the floating IP
-	droplet, err := r.GetDroplet(ctx, log, binding)
+	var droplet *Droplet
+	if TargetsDropletTag(binding) {
+		droplet, err = r.GetTaggedDroplet(ctx, log, binding)
+	} else {
+		droplet, err = r.GetDroplet(ctx, log, binding, binding.Spec.NodeSelector)
+	}
 	if err != nil {
-		return ctrl.Result{RequeueAfter: RequeueAfter}, err
+		return ctrl.Result{}, err
+	}
+	usedFallback := false
+	if droplet == nil && !TargetsDropletTag(binding) && binding.Spec.WhenNoCandidates == digitaloceanv1beta1.FallbackSelector {
+		log.Info("No dropletID found. Trying FallbackNodeSelector.")
+		droplet, err = r.GetDroplet(ctx, log, binding, binding.Spec.FallbackNodeSelector)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		usedFallback = droplet != nil
 	}
 	if droplet == nil {
-		log.Info("No dropletID found. Requeuing.")
-		return ctrl.Result{RequeueAfter: RequeueAfter}, err
+		return r.HandleNoCandidates(ctx, log, binding)
 	}
 
+	// Work out why the floating IP would move before status changes
+	reason := r.AssignmentReason(ctx, binding, droplet)
+
 	// Assign the droplet to the floating IP if required
-	err = r.AssignFloatingIP(ctx, log, binding, droplet)
+	action, err := r.AssignFloatingIP(ctx, log, binding, droplet)
+	var conflict *TakeoverConflictError
+	if errors.As(err, &conflict) {
+		log.Info("Refusing to take over floatingIP", "currentDropletID", conflict.DropletID)
+		newConflict := !meta.IsStatusConditionTrue(binding.Status.Conditions, digitaloceanv1beta1.ConditionConflict)
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionConflict,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: binding.Generation,
+			Reason:             "TakeoverRefused",
+			Message:            conflict.Error(),
+		})
+		if err := r.Status().Update(ctx, binding); err != nil {
+			log.Error(err, "Failed to update status")
+			return ctrl.Result{}, err
+		}
+		if newConflict {
+			r.Notify(ctx, log, binding, Notification{
+				Event:      digitaloceanv1beta1.NotifyConflict,
+				OldDroplet: &Droplet{ID: conflict.DropletID},
+				NewDroplet: droplet,
+				Reason:     "TakeoverRefused",
+				Message:    conflict.Error(),
+			})
+		}
+		return ctrl.Result{RequeueAfter: r.requeueInterval()}, nil
+	}
+	if errors.Is(err, ErrFrozen) {
+		log.Info("Controller is frozen. Not moving floatingIP.", "dropletID", droplet.ID)
+		r.Recorder.Eventf(binding, v1.EventTypeNormal, "Frozen",
+			"Controller is frozen, not moving floating IP to %s (%d)", droplet.Name, droplet.ID)
+		return ctrl.Result{RequeueAfter: r.requeueInterval()}, nil
+	}
 	if err != nil {
-		return ctrl.Result{RequeueAfter: RequeueAfter}, err
+		return ctrl.Result{}, err
+	}
+
+	// Only record the plan when in dry-run mode
+	if r.DryRunEnabled(binding) {
+		binding.Status.PlannedDroplet = &digitaloceanv1beta1.DropletReference{ID: droplet.ID, Name: droplet.Name}
+		if err := r.Status().Update(ctx, binding); err != nil {
+			log.Error(err, "Failed to update status")
+			return ctrl.Result{}, err
+		}
+		return result, nil
+	}
+
+	// Record the move in the history. The notification is sent once the status is persisted.
+	var assigned *Notification
+	if action != nil || binding.Status.AssignedDropletID != droplet.ID {
+		actionID := 0
+		if action != nil {
+			actionID = action.ID
+		}
+		RecordAssignment(binding, droplet, reason, actionID)
+		assigned = &Notification{
+			Event:      digitaloceanv1beta1.NotifyAssigned,
+			OldDroplet: statusDroplet(binding),
+			NewDroplet: droplet,
+			Reason:     string(reason),
+			Message:    fmt.Sprintf("Floating IP %s assigned to %s (%d)", binding.Spec.FloatingIP, droplet.Name, droplet.ID),
+		}
+
+		// Egress is routed again by the node agent on the new droplet
+		if binding.Spec.Egress != nil {
+			meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+				Type:               digitaloceanv1beta1.ConditionEgressReady,
+				Status:             metav1.ConditionUnknown,
+				ObservedGeneration: binding.Generation,
+				Reason:             "Pending",
+				Message:            fmt.Sprintf("Waiting for the node agent on %s to route egress", droplet.Name),
+			})
+		}
+	}
+
+	// Record a manual reassignment once the floating IP has moved, so the annotation
+	// value isn't acted on again
+	if ReassignRequested(binding) {
+		if action != nil {
+			r.RecordReassignment(binding, droplet)
+		} else {
+			log.Info("Reassignment requested but the floatingIP didn't move")
+			r.Recorder.Event(binding, v1.EventTypeWarning, "ReassignPending",
+				"Reassignment requested but no other node is eligible")
+		}
+	}
+
+	// Record the anchor network so egress can be routed through the floating IP
+	if err := r.UpdateAnchor(ctx, log, binding, droplet); err != nil {
+		r.Recorder.Event(binding, v1.EventTypeWarning, "AnchorLookupFailed", err.Error())
+		if result.RequeueAfter == 0 {
+			result.RequeueAfter = r.requeueInterval()
+		}
+	}
+
+	// Keep the DNS record pointing at the floating IP, checking for drift periodically
+	if binding.Spec.DNS != nil || binding.Status.DNSRecord != nil {
+		if err := r.SyncDNSRecord(ctx, log, binding); err != nil || binding.Spec.DNS != nil {
+			if result.RequeueAfter == 0 {
+				result.RequeueAfter = r.requeueInterval()
+			}
+		}
+	}
+
+	// Move the firewall membership with the floating IP
+	if binding.Spec.FirewallID != "" || binding.Status.Firewall != nil {
+		if err := r.SyncFirewall(ctx, log, binding, droplet); err != nil && result.RequeueAfter == 0 {
+			result.RequeueAfter = r.requeueInterval()
+		}
+	}
+
+	// Publish the floating IP in the Service's load balancer status
+	if binding.Spec.ServiceRef != nil || binding.Status.Service != nil {
+		if err := r.SyncService(ctx, log, binding); err != nil && result.RequeueAfter == 0 {
+			result.RequeueAfter = r.requeueInterval()
+		}
 	}
 
 	// Update status
 	binding.Status.AssignedDropletID = droplet.ID
 	binding.Status.AssignedDropletName = droplet.Name
+	binding.Status.PlannedDroplet = nil
+	binding.Status.ManagedIP = binding.Spec.FloatingIP
+	binding.Status.StalledCredentialsVersion = ""
+	meta.RemoveStatusCondition(&binding.Status.Conditions, digitaloceanv1beta1.ConditionStalled)
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionClaimed,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: binding.Generation,
+		Reason:             "Claimed",
+		Message:            fmt.Sprintf("Managed by controller %s", r.controllerName()),
+	})
+	if usedFallback {
+		r.Recorder.Eventf(binding, v1.EventTypeNormal, "FallbackSelector",
+			"No nodes match nodeSelector, assigned to %s using fallbackNodeSelector", droplet.Name)
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionAssigned,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: binding.Generation,
+			Reason:             "FallbackSelector",
+			Message:            "No nodes match nodeSelector, assigned using fallbackNodeSelector",
+		})
+	} else if TargetsDropletTag(binding) {
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionAssigned,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: binding.Generation,
+			Reason:             "DropletTag",
+			Message:            "Floating IP is assigned to a droplet with dropletTag",
+		})
+	} else {
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionAssigned,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: binding.Generation,
+			Reason:             "NodeSelector",
+			Message:            "Floating IP is assigned to a node matching nodeSelector",
+		})
+	}
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionConflict,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: binding.Generation,
+		Reason:             "Assigned",
+		Message:            "Floating IP is assigned to the selected droplet",
+	})
 	err = r.Status().Update(ctx, binding)
 	if err != nil {
 		log.Error(err, "Failed to update status")
-		return ctrl.Result{RequeueAfter: RequeueAfter}, err
+		return ctrl.Result{}, err
+	}
+	if assigned != nil {
+		r.Notify(ctx, log, binding, *assigned)
+	}
+
+	// Mark the node holding the floating IP
+	if err := r.LabelNodes(ctx, log, binding, droplet.Node); err != nil {
+		r.Recorder.Event(binding, v1.EventTypeWarning, "NodeLabelFailed", err.Error())
+		// The floating IP is already assigned, so retry rather than park the binding
+		return ctrl.Result{}, Transient(err)
 	}
 
-	return ctrl.Result{}, nil
+	return result, nil
+}
+
+// ReassignRequested returns true when the reassign annotation has a value which
+// hasn't been acted on yet
+func ReassignRequested(binding *digitaloceanv1beta1.FloatingIPBinding) bool {
+	value, ok := binding.GetAnnotations()[digitaloceanv1beta1.ReassignAnnotation]
+	if !ok || value == "" {
+		return false
+	}
+	return binding.Status.LastReassign == nil || binding.Status.LastReassign.Value != value
+}
+
+// requeueInterval returns how long to wait before reconciling a binding again
+func (r *FloatingIPBindingReconciler) requeueInterval() time.Duration {
+	if r.RequeueInterval > 0 {
+		return r.RequeueInterval
+	}
+	return RequeueAfter
+}
+
+// tagPollInterval returns how long to wait before polling the droplets of a DropletTag again
+func (r *FloatingIPBindingReconciler) tagPollInterval() time.Duration {
+	if r.TagPollInterval > 0 {
+		return r.TagPollInterval
+	}
+	return DropletTagPollInterval
+}
+
+// DryRunEnabled returns true when floating IP actions should be skipped for the binding
+func (r *FloatingIPBindingReconciler) DryRunEnabled(binding *digitaloceanv1beta1.FloatingIPBinding) bool {
+	return r.DryRun || binding.Spec.DryRun
+}
+
+// RecordReassignment records a manual reassignment to droplet in the binding's status
+func (r *FloatingIPBindingReconciler) RecordReassignment(
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	droplet *Droplet,
+) {
+	reassignment := &digitaloceanv1beta1.Reassignment{
+		Value: binding.GetAnnotations()[digitaloceanv1beta1.ReassignAnnotation],
+		Time:  metav1.Now(),
+		To:    &digitaloceanv1beta1.DropletReference{ID: droplet.ID, Name: droplet.Name},
+	}
+	if binding.Status.AssignedDropletID != 0 {
+		reassignment.From = &digitaloceanv1beta1.DropletReference{
+			ID:   binding.Status.AssignedDropletID,
+			Name: binding.Status.AssignedDropletName,
+		}
+	}
+	binding.Status.LastReassign = reassignment
+	r.Recorder.Eventf(binding, v1.EventTypeNormal, "Reassigned",
+		"Manually reassigned floating IP to %s (%d)", droplet.Name, droplet.ID)
+}
+
+// HandleNoCandidates applies the WhenNoCandidates policy when no nodes could be selected
+func (r *FloatingIPBindingReconciler) HandleNoCandidates(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) (ctrl.Result, error) {
+	condition := metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionAssigned,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: binding.Generation,
+	}
+	policy := binding.Spec.WhenNoCandidates
+	if policy == digitaloceanv1beta1.Unassign && r.Freeze {
+		log.Info("Controller is frozen. Not unassigning.")
+		policy = digitaloceanv1beta1.Keep
+	}
+	switch policy {
+	case digitaloceanv1beta1.Unassign:
+		if r.DryRunEnabled(binding) {
+			log.Info("No dropletID found. Would unassign.")
+			binding.Status.PlannedDroplet = nil
+			r.Recorder.Event(binding, v1.EventTypeNormal, "DryRun", "Would unassign floating IP as no nodes match the selector")
+			if err := r.Status().Update(ctx, binding); err != nil {
+				log.Error(err, "Failed to update status")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: r.requeueInterval()}, nil
+		}
+		log.Info("No dropletID found. Unassigning.")
+		err := r.UnassignFloatingIP(ctx, log, binding)
+		var conflict *TakeoverConflictError
+		if errors.As(err, &conflict) {
+			log.Info("Refusing to unassign floatingIP", "currentDropletID", conflict.DropletID)
+			condition.Reason = "TakeoverRefused"
+			condition.Message = conflict.Error()
+			r.Recorder.Event(binding, v1.EventTypeWarning, "TakeoverRefused", condition.Message)
+			break
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.LabelNodes(ctx, log, binding, ""); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.RemoveFromFirewall(ctx, log, binding); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.UnpublishService(ctx, log, binding); err != nil {
+			return ctrl.Result{}, err
+		}
+		binding.Status.AssignedDropletID = 0
+		binding.Status.AssignedDropletName = ""
+		binding.Status.Anchor = nil
+		EndAssignment(binding, metav1.Now())
+		condition.Reason = "Unassigned"
+		condition.Message = "No nodes match the selector, floating IP has been unassigned"
+		r.Recorder.Event(binding, v1.EventTypeWarning, "Unassigned", condition.Message)
+	default:
+		log.Info("No dropletID found. Requeuing.")
+		condition.Reason = "NoCandidates"
+		condition.Message = "No nodes match the selector, floating IP has been kept on its current droplet"
+		r.Recorder.Event(binding, v1.EventTypeWarning, "NoCandidates", condition.Message)
+	}
+
+	meta.SetStatusCondition(&binding.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, binding); err != nil {
+		log.Error(err, "Failed to update status")
+		return ctrl.Result{}, err
+	}
+	if TargetsDropletTag(binding) {
+		return ctrl.Result{RequeueAfter: r.tagPollInterval()}, nil
+	}
+	return ctrl.Result{RequeueAfter: r.requeueInterval()}, nil
 }
 
 func (r *FloatingIPBindingReconciler) nodeToRequests(node client.Object) []reconcile.Request {
-	// Whenever any node every happens reconcile ALL FloatingIPBindings
+	// Whenever any node every happens reconcile ALL FloatingIPBindings of this instance
 	// List all bindings
 	var bindings digitaloceanv1beta1.FloatingIPBindingList
 	err := r.List(context.Background(), &bindings)
@@ -123,7 +592,11 @@ func (r *FloatingIPBindingReconciler) nodeToRequests(node client.Object) []recon
 
 	// Convert FloatingIPBindingList to []reconcile.Request
 	var reconcileRequests []reconcile.Request
-	for _, binding := range bindings.Items {
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if !r.Claims(binding) {
+			continue
+		}
 		reconcileRequests = append(reconcileRequests, reconcile.Request{
 			NamespacedName: types.NamespacedName{
 				Name:      binding.GetName(),
@@ -157,74 +630,108 @@ func (r *FloatingIPBindingReconciler) GetDroplet(
 	ctx context.Context,
 	log logr.Logger,
 	binding *digitaloceanv1beta1.FloatingIPBinding,
+	nodeSelector *metav1.LabelSelector,
 ) (*Droplet, error) {
 	var err error
 
 	// Get NodeSelector or default to everything
 	var selector labels.Selector
-	if binding.Spec.NodeSelector == nil {
+	if nodeSelector == nil {
 		selector = labels.Everything()
 	} else {
-		selector, err = metav1.LabelSelectorAsSelector(binding.Spec.NodeSelector)
+		selector, err = metav1.LabelSelectorAsSelector(nodeSelector)
 		if err != nil {
 			log.Error(err, "Could not parse NodeSelector")
 			return nil, err
 		}
 	}
 
-	// Get list of nodes
-	var nodes v1.NodeList
-	err = r.Client.List(ctx, &nodes, client.MatchingLabelsSelector{Selector: selector})
+	// Get list of all nodes so that filtered nodes can be reported
+	var allNodes v1.NodeList
+	err = r.Client.List(ctx, &allNodes)
 	if err != nil {
 		log.Error(err, "Could not list nodes")
 		return nil, err
 	}
-	if len(nodes.Items) == 0 {
-		log.Info("No nodes matching NodeSelector")
-		return nil, nil
-	}
 
 	// Sort nodes by Age
-	sort.SliceStable(nodes.Items, func(i, j int) bool {
-		return nodes.Items[i].CreationTimestamp.Before(&nodes.Items[j].CreationTimestamp)
+	sort.SliceStable(allNodes.Items, func(i, j int) bool {
+		return allNodes.Items[i].CreationTimestamp.Before(&allNodes.Items[j].CreationTimestamp)
 	})
 
-	// Choose node based on NodeSelectorPolicy
-	var node *v1.Node
-	switch binding.Spec.NodeSelectorPolicy {
-	case digitaloceanv1beta1.Newest:
-		// Select the first in the list
-		node = &nodes.Items[len(nodes.Items)-1]
-	case digitaloceanv1beta1.Oldest:
-		// Select the first in the list
-		node = &nodes.Items[0]
-	case digitaloceanv1beta1.Random:
-		// If already randomly assigned select the same node
-		for _, n := range nodes.Items {
-			if n.GetName() == binding.Status.AssignedDropletName {
-				node = &n
-				log.Info("Randomly assigned droplet still exists. Skipping.")
-				break
-			}
+	// Filter nodes by NodeSelector
+	var matching []v1.Node
+	var filtered []digitaloceanv1beta1.Candidate
+	for _, n := range allNodes.Items {
+		if selector.Matches(labels.Set(n.GetLabels())) {
+			matching = append(matching, n)
+		} else {
+			filtered = append(filtered, digitaloceanv1beta1.Candidate{
+				Name:              n.GetName(),
+				CreationTimestamp: n.CreationTimestamp,
+				FilteredReason:    "NodeSelector",
+			})
 		}
-		if node == nil {
-			// If current node isn't found select a new one
-			i := rand.IntnRange(0, len(nodes.Items))
-			node = &nodes.Items[i]
+	}
+
+	// Filter nodes which aren't backed by a droplet
+	dropletIDs := r.ResolveDropletIDs(ctx, log, binding, matching)
+	var eligible []candidate
+	for _, n := range matching {
+		dropletID, ok := dropletIDs[n.GetName()]
+		if !ok {
+			filtered = append(filtered, digitaloceanv1beta1.Candidate{
+				Name:              n.GetName(),
+				CreationTimestamp: n.CreationTimestamp,
+				FilteredReason:    "NoDroplet",
+			})
+			continue
 		}
-	default:
-		return nil, fmt.Errorf("Invalid NodeSelectorPolicy: %s", binding.Spec.NodeSelectorPolicy)
+		eligible = append(eligible, candidate{
+			Droplet: Droplet{ID: dropletID, Name: n.GetName(), Node: n.GetName()},
+			Created: n.CreationTimestamp,
+		})
 	}
 
-	// Get dropletID int ID from providerId
-	providerIdParts := strings.Split(node.Spec.ProviderID, "//")
-	providerIdStr := providerIdParts[len(providerIdParts)-1]
-	dropletID, err := strconv.Atoi(providerIdStr)
-	if err != nil {
-		log.Error(err, "Could not convert providerId to int")
-		return nil, err
+	return r.SelectCandidate(log, binding, eligible, filtered)
+}
+
+// CanTakeOver checks whether the TakeoverPolicy allows the floating IP to be moved
+// away from the droplet with the given ID
+func (r *FloatingIPBindingReconciler) CanTakeOver(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	currentDropletID int,
+) (bool, error) {
+	switch binding.Spec.TakeoverPolicy {
+	case digitaloceanv1beta1.Always, "":
+		return true, nil
+	case digitaloceanv1beta1.IfUnassigned:
+		return false, nil
+	case digitaloceanv1beta1.IfClusterNode:
+		// Droplets with the tag are managed by the binding too
+		if TargetsDropletTag(binding) {
+			tagged, err := r.HasDropletTag(ctx, log, binding, currentDropletID)
+			if err != nil || tagged {
+				return tagged, err
+			}
+		}
+		// Check the current droplet against every node in the cluster, not just the selected ones
+		var nodes v1.NodeList
+		if err := r.Client.List(ctx, &nodes); err != nil {
+			log.Error(err, "Could not list nodes")
+			return false, err
+		}
+		for _, dropletID := range r.ResolveDropletIDs(ctx, log, binding, nodes.Items) {
+			if dropletID == currentDropletID {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, Permanent(fmt.Errorf("Invalid TakeoverPolicy: %s", binding.Spec.TakeoverPolicy))
 	}
-	return &Droplet{ID: dropletID, Name: node.Name}, nil
 }
 
 func (r *FloatingIPBindingReconciler) AssignFloatingIP(
@@ -232,39 +739,165 @@ func (r *FloatingIPBindingReconciler) AssignFloatingIP(
 	log logr.Logger,
 	binding *digitaloceanv1beta1.FloatingIPBinding,
 	droplet *Droplet,
-) error {
+) (*godo.Action, error) {
 	// Use digitalocean API to assign floating IP
 	log = log.WithValues(
 		"dropletID", droplet.ID,
 		"dropletName", droplet.Name,
 		"floatingIP", binding.Spec.FloatingIP,
 	)
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		r.Recorder.Event(binding, v1.EventTypeWarning, "CredentialsError", err.Error())
+		return nil, err
+	}
+
 	// Get IP to see if it is already assigned
-	ip, _, err := r.DigitaloceanClient.FloatingIPs.Get(ctx, binding.Spec.FloatingIP)
+	ip, err := doProvider.GetFloatingIP(ctx, binding.Spec.FloatingIP)
 	if err != nil {
 		log.Error(err, "Failed to get floatingIP")
-		return err
+		if provider.IsNotFound(err) {
+			return nil, Permanent(err)
+		}
+		return nil, err
 	}
 
 	// Assign droplet to floating IP if not already assigned
 	if ip.Droplet != nil && ip.Droplet.ID == droplet.ID {
 		log.Info("Droplet is already assigned to floatingIP. Skipping.")
 	} else {
+		// Check the policy allows taking the IP from its current droplet. The droplet the
+		// binding assigned it to is always allowed, even once its node has left the cluster.
+		if ip.Droplet != nil {
+			if r.Freeze {
+				return nil, ErrFrozen
+			}
+			ok, err := r.takeoverAllowed(ctx, log, binding)(ip.Droplet.ID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, &TakeoverConflictError{
+					FloatingIP: binding.Spec.FloatingIP,
+					DropletID:  ip.Droplet.ID,
+					Policy:     binding.Spec.TakeoverPolicy,
+				}
+			}
+		}
+
+		if r.DryRunEnabled(binding) {
+			log.Info("Dry-run enabled. Skipping assign.")
+			r.Recorder.Eventf(binding, v1.EventTypeNormal, "DryRun",
+				"Would assign floating IP to droplet %s (%d)", droplet.Name, droplet.ID)
+			return nil, nil
+		}
+
 		// Assign IP if not already assigned
-		_, _, err = r.DigitaloceanClient.FloatingIPActions.Assign(ctx, binding.Spec.FloatingIP, droplet.ID)
+		action, err := doProvider.AssignFloatingIP(ctx, binding.Spec.FloatingIP, droplet.ID)
 		if err != nil {
-			// Check that the error isn't a 422. This occurs if we are already updating the IP
-			doError, ok := err.(*godo.ErrorResponse)
-			if ok && doError.Response.StatusCode == 422 {
-				log.Info("FloatingIP is in pending state. Skipping.")
-				return nil
-			} else {
-				log.Error(err, "Failed update floatingIP")
-				return err
+			// A 422 occurs if the IP is already being updated, so retry once it has finished
+			if provider.IsPending(err) {
+				log.Info("FloatingIP is in pending state. Retrying.")
+				return nil, err
 			}
+			log.Error(err, "Failed update floatingIP")
+			return nil, err
+		}
+		log.Info("Assigned droplet to FloatingIP", "actionID", action.ID)
+		return action, nil
+	}
+
+	return nil, nil
+}
+
+// UnassignFloatingIP unassigns the binding's floating IP. A droplet the binding didn't
+// assign it to is only unassigned when the TakeoverPolicy allows taking it over.
+func (r *FloatingIPBindingReconciler) UnassignFloatingIP(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	return r.unassignIP(ctx, log, binding, binding.Spec.FloatingIP, r.takeoverAllowed(ctx, log, binding))
+}
+
+// takeoverAllowed accepts the droplet the binding assigned and any droplet the
+// TakeoverPolicy allows taking the floating IP from
+func (r *FloatingIPBindingReconciler) takeoverAllowed(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) func(dropletID int) (bool, error) {
+	return func(dropletID int) (bool, error) {
+		if dropletID == binding.Status.AssignedDropletID {
+			return true, nil
+		}
+		return r.CanTakeOver(ctx, log, binding, dropletID)
+	}
+}
+
+// assignedByBinding only accepts the droplet the binding assigned the floating IP to, so
+// a floating IP which has since moved to another droplet is left where it is
+func assignedByBinding(binding *digitaloceanv1beta1.FloatingIPBinding) func(dropletID int) (bool, error) {
+	return func(dropletID int) (bool, error) {
+		return dropletID != 0 && dropletID == binding.Status.AssignedDropletID, nil
+	}
+}
+
+// unassignIP unassigns a floating IP using the binding's credentials when allowed
+// accepts the droplet holding it, returning a TakeoverConflictError otherwise. A pending
+// action on the floating IP is returned so the unassign is retried.
+func (r *FloatingIPBindingReconciler) unassignIP(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	floatingIP string,
+	allowed func(dropletID int) (bool, error),
+) error {
+	log = log.WithValues("floatingIP", floatingIP)
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		r.Recorder.Event(binding, v1.EventTypeWarning, "CredentialsError", err.Error())
+		return err
+	}
+
+	// Get IP to see if it is assigned
+	ip, err := doProvider.GetFloatingIP(ctx, floatingIP)
+	if provider.IsNotFound(err) {
+		log.Info("FloatingIP doesn't exist. Skipping.")
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to get floatingIP")
+		return err
+	}
+	if ip.Droplet == nil {
+		log.Info("FloatingIP is not assigned. Skipping.")
+		return nil
+	}
+	ok, err := allowed(ip.Droplet.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &TakeoverConflictError{
+			FloatingIP: floatingIP,
+			DropletID:  ip.Droplet.ID,
+			Policy:     binding.Spec.TakeoverPolicy,
 		}
-		log.Info("Assigned droplet to FloatingIP")
 	}
 
+	_, err = doProvider.UnassignFloatingIP(ctx, floatingIP)
+	if err != nil {
+		// A 422 occurs if the IP is already being updated, so retry once it has finished
+		if provider.IsPending(err) {
+			log.Info("FloatingIP is in pending state. Retrying.")
+			return err
+		}
+		log.Error(err, "Failed to unassign floatingIP")
+		return err
+	}
+	log.Info("Unassigned FloatingIP", "dropletID", ip.Droplet.ID)
 	return nil
 }
@@ -17,61 +17,72 @@ limitations under the License.
 package digitalocean
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"time"
 
 	"github.com/digitalocean/godo"
-	"github.com/jarcoal/httpmock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/notify"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
 )
 
-type floatingIPRoot struct {
-	FloatingIP *godo.FloatingIP `json:"floating_ip"`
-}
-type actionRoot struct {
-	Event *godo.Action `json:"action"`
-}
-
-const TestIP string = "1.2.3.4"
-
-var (
-	node1 = v1.Node{
-		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
-		Spec:       v1.NodeSpec{ProviderID: "digitalocean://12345678"},
+// createNode creates a node with a droplet in the fake provider. Nodes are deleted after
+// each spec, so every spec creates the nodes its bindings choose from.
+func createNode(name string, dropletID int) {
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{ProviderID: fmt.Sprintf("digitalocean://%d", dropletID)},
 	}
-	getResponseUnassigned = floatingIPRoot{
-		FloatingIP: &godo.FloatingIP{IP: TestIP},
-	}
-	// getResponseAssigned = floatingIPRoot{
-	// 	FloatingIP: &godo.FloatingIP{IP: TestIP, Droplet: &godo.Droplet{ID: 12345678}},
-	// }
-	assignResponse = actionRoot{Event: &godo.Action{}}
-)
+	Expect(k8sClient.Create(ctx, &node)).Should(Succeed(), "failed to create test node")
+	fakeProvider.AddDroplet(godo.Droplet{ID: dropletID, Name: name})
+}
 
 var _ = Context("Floating IP Controller", func() {
 
+	// Bindings left behind would keep reconciling their floating IPs and nodes would stay
+	// candidates of later specs' bindings
+	AfterEach(func() {
+		By("Deleting the claims, bindings and nodes")
+		Expect(k8sClient.DeleteAllOf(ctx, &digitaloceanv1beta1.FloatingIPClaim{}, client.InNamespace("default"))).
+			Should(Succeed(), "failed to delete test claims")
+		Eventually(
+			func() ([]digitaloceanv1beta1.FloatingIPBinding, error) {
+				// The claim controller may recreate a binding until it sees its claim is gone
+				err := k8sClient.DeleteAllOf(ctx, &digitaloceanv1beta1.FloatingIPBinding{}, client.InNamespace("default"))
+				if err != nil {
+					return nil, err
+				}
+				bindings := &digitaloceanv1beta1.FloatingIPBindingList{}
+				err = k8sClient.List(ctx, bindings, client.InNamespace("default"))
+				return bindings.Items, err
+			},
+			time.Second*5, time.Millisecond*100,
+		).Should(BeEmpty(), "bindings should be finalized")
+		Expect(k8sClient.DeleteAllOf(ctx, &digitaloceanv1beta1.FloatingIPClass{})).
+			Should(Succeed(), "failed to delete test classes")
+		Expect(k8sClient.DeleteAllOf(ctx, &digitaloceanv1beta1.FloatingIPNotifier{}, client.InNamespace("default"))).
+			Should(Succeed(), "failed to delete test notifiers")
+		Expect(k8sClient.DeleteAllOf(ctx, &v1.Node{})).Should(Succeed(), "failed to delete test nodes")
+	})
+
 	Describe("when a new resources is created", func() {
 		It("should assign a floating ip to a node", func() {
 
-			By("Adding Node")
-			Expect(k8sClient.Create(ctx, &node1)).Should(Succeed(), "failed to create test binding")
-
-			By("Adding httpmocks")
-			httpmock.RegisterResponder(
-				"GET",
-				"/v2/floating_ips/1.2.3.4",
-				httpmock.NewJsonResponderOrPanic(200, getResponseUnassigned),
-			)
-			httpmock.RegisterResponder(
-				"POST",
-				"/v2/floating_ips/1.2.3.4/actions",
-				httpmock.NewJsonResponderOrPanic(200, assignResponse),
-			)
+			By("Adding a node, droplet and floating ip")
+			createNode("node1", 12345678)
+			fakeProvider.AddFloatingIP("1.2.3.4", 0)
 
 			By("Creating a binding")
 			key := client.ObjectKey{
@@ -88,7 +99,6 @@ var _ = Context("Floating IP Controller", func() {
 				},
 			}
 			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
-			Expect(httpmock.GetCallCountInfo()).To(HaveLen(2))
 
 			By("Checking the status has updated")
 			Eventually(
@@ -99,8 +109,1453 @@ var _ = Context("Floating IP Controller", func() {
 				},
 				time.Second*1, time.Millisecond*100,
 			).Should(BeTrue(), "Certificate should be set")
+			Expect(fakeProvider.AssignedDropletID("1.2.3.4")).To(Equal(12345678))
+		})
+
+		It("should report the ranked candidates in status", func() {
+
+			By("Adding a node, droplet and floating ip")
+			createNode("candidate", 89012800)
+			fakeProvider.AddFloatingIP("1.2.3.8", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-candidates",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.8",
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the candidates are reported")
+			Eventually(
+				func() []digitaloceanv1beta1.Candidate {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return binding.Status.Candidates
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(HaveLen(1))
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			Expect(binding.Status.Candidates[0].Name).To(Equal("candidate"))
+			Expect(binding.Status.Candidates[0].DropletID).To(Equal(89012800))
+			Expect(binding.Status.Candidates[0].Score).To(Equal(1))
+		})
+
+	})
+
+	Describe("when the floating IP is assigned to a droplet outside the cluster", func() {
+		It("should refuse to take it over with IfClusterNode", func() {
+
+			By("Adding a node, droplets and floating ip")
+			createNode("takeover", 89012801)
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012802, Name: "outside"})
+			fakeProvider.AddFloatingIP("1.2.3.12", 89012802)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-takeover",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP:     "1.2.3.12",
+					TakeoverPolicy: digitaloceanv1beta1.IfClusterNode,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the conflict condition is set")
+			Eventually(
+				func() bool {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return meta.IsStatusConditionTrue(binding.Status.Conditions, digitaloceanv1beta1.ConditionConflict)
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(BeTrue(), "Conflict condition should be set")
+			Expect(fakeProvider.Calls("AssignFloatingIP")).To(BeZero())
+			Expect(fakeProvider.AssignedDropletID("1.2.3.12")).To(Equal(89012802))
+		})
+
+		It("should move it off its own droplet with IfClusterNode once that node leaves", func() {
+
+			By("Adding a node, droplets and floating ip")
+			leaving := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "takeover-leaving",
+					Labels: map[string]string{"role": "takeover-own"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://89012700"},
+			}
+			Expect(k8sClient.Create(ctx, &leaving)).Should(Succeed(), "failed to create test node")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012700, Name: "takeover-leaving"})
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012701, Name: "takeover-joining"})
+			fakeProvider.AddFloatingIP("1.2.3.11", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-takeover-own",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP:     "1.2.3.11",
+					TakeoverPolicy: digitaloceanv1beta1.IfClusterNode,
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "takeover-own"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.11") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(89012700))
+
+			By("Replacing the node")
+			joining := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "takeover-joining",
+					Labels: map[string]string{"role": "takeover-own"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://89012701"},
+			}
+			Expect(k8sClient.Create(ctx, &joining)).Should(Succeed(), "failed to create test node")
+			Expect(k8sClient.Delete(ctx, &leaving)).Should(Succeed(), "failed to delete test node")
+
+			By("Checking the floating ip moves off the droplet the binding assigned it to")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.11") },
+				time.Second*2, time.Millisecond*100,
+			).Should(Equal(89012701))
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			Expect(meta.IsStatusConditionTrue(binding.Status.Conditions, digitaloceanv1beta1.ConditionConflict)).To(BeFalse())
+		})
+	})
+
+	Describe("when no nodes match the selector", func() {
+		It("should unassign the floating ip with Unassign", func() {
+
+			By("Adding a node, droplets and floating ip")
+			createNode("unselected", 89012803)
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012804, Name: "outside"})
+			fakeProvider.AddFloatingIP("1.2.3.13", 89012804)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-no-candidates",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.13",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "missing"},
+					},
+					WhenNoCandidates: digitaloceanv1beta1.Unassign,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the floating ip was unassigned")
+			Eventually(
+				func() string {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionAssigned)
+					if condition == nil {
+						return ""
+					}
+					return condition.Reason
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal("Unassigned"), "Assigned condition should be Unassigned")
+			Expect(fakeProvider.Calls("UnassignFloatingIP")).To(Equal(1))
+			Expect(fakeProvider.AssignedDropletID("1.2.3.13")).To(BeZero())
+		})
+
+		It("should leave a floating ip the takeover policy protects with Unassign", func() {
+
+			By("Adding a node, droplets and floating ip")
+			createNode("protected", 89012805)
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012806, Name: "outside"})
+			fakeProvider.AddFloatingIP("1.2.3.5", 89012806)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-no-candidates-protected",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.5",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "missing"},
+					},
+					TakeoverPolicy:   digitaloceanv1beta1.IfClusterNode,
+					WhenNoCandidates: digitaloceanv1beta1.Unassign,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the floating ip was left assigned")
+			Eventually(
+				func() string {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionAssigned)
+					if condition == nil {
+						return ""
+					}
+					return condition.Reason
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal("TakeoverRefused"), "Assigned condition should be TakeoverRefused")
+			Expect(fakeProvider.Calls("UnassignFloatingIP")).To(BeZero())
+			Expect(fakeProvider.AssignedDropletID("1.2.3.5")).To(Equal(89012806))
+		})
+	})
+
+	Describe("when a binding references a credentials secret", func() {
+		It("should assign a floating ip using the secret's token", func() {
+
+			By("Adding a node, droplet and floating ip")
+			createNode("credentials", 89012807)
+			fakeProvider.AddFloatingIP("1.2.3.14", 0)
+
+			By("Creating a secret")
+			secret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-token", Namespace: "default"},
+				StringData: map[string]string{"token": "abc123"},
+			}
+			Expect(k8sClient.Create(ctx, secret)).Should(Succeed(), "failed to create test secret")
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-credentials",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.14",
+					CredentialsRef: &digitaloceanv1beta1.CredentialsReference{
+						Name: "team-token",
+						Key:  "token",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the status has updated")
+			Eventually(
+				func() int {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return binding.Status.AssignedDropletID
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(89012807), "AssignedDropletID should be set")
+		})
+
+		It("should build the provider from the secret's token and rebuild it when rotated", func() {
+
+			By("Adding a node, droplet and floating ip")
+			createNode("rotating-credentials", 89012808)
+			fakeProvider.AddFloatingIP("1.2.3.6", 0)
+
+			By("Creating a secret")
+			secret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "rotating-token", Namespace: "default"},
+				StringData: map[string]string{"token": "first-token"},
+			}
+			Expect(k8sClient.Create(ctx, secret)).Should(Succeed(), "failed to create test secret")
+
+			By("Creating a binding")
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "floatingipbinding-rotating-credentials",
+					Namespace: "default",
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.6",
+					CredentialsRef: &digitaloceanv1beta1.CredentialsReference{
+						Name: "rotating-token",
+						Key:  "token",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the provider is built from the secret's token")
+			Eventually(providerTokens.list, time.Second*1, time.Millisecond*100).Should(ContainElement("first-token"))
+
+			By("Rotating the token")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), secret)).Should(Succeed(), "failed to get secret")
+			secret.StringData = map[string]string{"token": "second-token"}
+			Expect(k8sClient.Update(ctx, secret)).Should(Succeed(), "failed to update secret")
+
+			By("Checking the provider is rebuilt from the new token")
+			Eventually(providerTokens.list, time.Second*1, time.Millisecond*100).Should(ContainElement("second-token"))
+		})
+	})
+
+	Describe("when the floating IP is locked by another action", func() {
+		It("should assign the floating ip once it is unlocked", func() {
+
+			By("Adding a node, droplet and a locked floating ip")
+			createNode("locked", 89012809)
+			fakeProvider.AddFloatingIP("1.2.3.15", 0)
+			fakeProvider.FailNext("AssignFloatingIP", &provider.StatusError{StatusCode: 422, Message: "locked"})
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-locked",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.15",
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Triggering another reconcile")
+			Eventually(func() int { return fakeProvider.Calls("AssignFloatingIP") }).Should(Equal(1))
+			Expect(fakeProvider.AssignedDropletID("1.2.3.15")).To(BeZero())
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			binding.Labels = map[string]string{"retry": "true"}
+			Expect(k8sClient.Update(ctx, binding)).Should(Succeed(), "failed to update binding")
+
+			By("Checking the floating ip is assigned")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.15") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(89012809))
 		})
+	})
+
+	Describe("when a binding is in dry-run mode", func() {
+		It("should record the planned droplet without assigning", func() {
+
+			By("Adding a node, droplet and floating ip")
+			createNode("dry-run", 89012810)
+			fakeProvider.AddFloatingIP("1.2.3.16", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-dry-run",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.16",
+					DryRun:     true,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
 
+			By("Checking the planned droplet is set")
+			Eventually(
+				func() *digitaloceanv1beta1.DropletReference {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return binding.Status.PlannedDroplet
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(&digitaloceanv1beta1.DropletReference{ID: 89012810, Name: "dry-run"}))
+			Expect(fakeProvider.Calls("AssignFloatingIP")).To(BeZero())
+			Expect(fakeProvider.AssignedDropletID("1.2.3.16")).To(BeZero())
+		})
 	})
 
+	Describe("when the reassign annotation is set", func() {
+		It("should move the floating ip to another node once", func() {
+
+			By("Adding two nodes, droplets and floating ip")
+			createNode("reassign-a", 89012811)
+			// Creation timestamps have a resolution of one second
+			time.Sleep(time.Second)
+			createNode("reassign-b", 89012812)
+			fakeProvider.AddFloatingIP("1.2.3.17", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-reassign",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP:         "1.2.3.17",
+					NodeSelectorPolicy: digitaloceanv1beta1.Oldest,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.17") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(89012811))
+
+			By("Setting the reassign annotation")
+			Eventually(func() error {
+				Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+				binding.Annotations = map[string]string{digitaloceanv1beta1.ReassignAnnotation: "2021-01-01T03:12:00Z"}
+				return k8sClient.Update(ctx, binding)
+			}).Should(Succeed(), "failed to annotate binding")
+
+			By("Checking the floating ip moved and the reassignment is recorded")
+			Eventually(
+				func() *digitaloceanv1beta1.Reassignment {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return binding.Status.LastReassign
+				},
+				time.Second*1, time.Millisecond*100,
+			).ShouldNot(BeNil())
+			Expect(fakeProvider.AssignedDropletID("1.2.3.17")).To(Equal(89012812))
+
+			By("Checking the history records both assignments")
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			Expect(binding.Status.History).To(HaveLen(2))
+			Expect(binding.Status.History[0].DropletID).To(Equal(89012811))
+			Expect(binding.Status.History[0].End).NotTo(BeNil())
+			Expect(binding.Status.History[1].DropletID).To(Equal(89012812))
+			Expect(binding.Status.History[1].Reason).To(Equal(digitaloceanv1beta1.ReasonManual))
+			Expect(binding.Status.History[1].End).To(BeNil())
+			Consistently(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.17") },
+				time.Millisecond*500, time.Millisecond*100,
+			).Should(Equal(89012812))
+		})
+		It("should resume the node selector policy once it chooses another node", func() {
+			pinNode := func(name string, dropletID int) {
+				node := v1.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   name,
+						Labels: map[string]string{"role": "pin"},
+					},
+					Spec: v1.NodeSpec{ProviderID: fmt.Sprintf("digitalocean://%d", dropletID)},
+				}
+				Expect(k8sClient.Create(ctx, &node)).Should(Succeed(), "failed to create test node")
+				fakeProvider.AddDroplet(godo.Droplet{ID: dropletID, Name: name})
+				// Creation timestamps have a resolution of one second
+				time.Sleep(time.Second)
+			}
+
+			By("Adding two nodes and a floating ip")
+			pinNode("pin-a", 89012500)
+			pinNode("pin-b", 89012501)
+			fakeProvider.AddFloatingIP("1.2.3.7", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-reassign-resume",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP:         "1.2.3.7",
+					NodeSelectorPolicy: digitaloceanv1beta1.Newest,
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "pin"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.7") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(89012501))
+
+			By("Reassigning to the older node")
+			Eventually(func() error {
+				Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+				binding.Annotations = map[string]string{digitaloceanv1beta1.ReassignAnnotation: "2021-01-01T03:12:00Z"}
+				return k8sClient.Update(ctx, binding)
+			}).Should(Succeed(), "failed to annotate binding")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.7") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(89012500))
+			Consistently(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.7") },
+				time.Millisecond*500, time.Millisecond*100,
+			).Should(Equal(89012500))
+
+			By("Adding a newer node")
+			pinNode("pin-c", 89012502)
+
+			By("Checking the floating ip moves to the newest node")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.7") },
+				time.Second*2, time.Millisecond*100,
+			).Should(Equal(89012502))
+		})
+	})
+
+	Describe("when nodes don't have a DigitalOcean providerID", func() {
+		It("should skip them or resolve their droplet by name", func() {
+
+			By("Adding nodes")
+			selfManaged := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "self-managed",
+					Labels: map[string]string{"role": "self-managed"},
+				},
+			}
+			otherCloud := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "other-cloud",
+					Labels: map[string]string{"role": "self-managed"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "aws:///eu-west-1a/i-0123456789"},
+			}
+			Expect(k8sClient.Create(ctx, &selfManaged)).Should(Succeed(), "failed to create test node")
+			Expect(k8sClient.Create(ctx, &otherCloud)).Should(Succeed(), "failed to create test node")
+
+			By("Adding droplets and floating ip")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 34567890, Name: "self-managed"})
+			fakeProvider.AddFloatingIP("1.2.3.18", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-self-managed",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.18",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "self-managed"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the floating ip is assigned to the resolved droplet")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.18") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(34567890))
+			Eventually(
+				func() string {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					for _, candidate := range binding.Status.Candidates {
+						if candidate.Name == "other-cloud" {
+							return candidate.FilteredReason
+						}
+					}
+					return ""
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal("NoDroplet"))
+		})
+	})
+
+	Describe("when the binding targets a droplet tag", func() {
+		It("should assign the floating ip to the oldest tagged droplet", func() {
+
+			By("Adding tagged droplets and floating ip")
+			fakeProvider.AddDroplet(godo.Droplet{
+				ID: 45678901, Name: "bastion-old", Tags: []string{"bastion"}, Created: "2021-01-01T00:00:00Z",
+			})
+			fakeProvider.AddDroplet(godo.Droplet{
+				ID: 45678902, Name: "bastion-new", Tags: []string{"bastion"}, Created: "2021-06-01T00:00:00Z",
+			})
+			fakeProvider.AddDroplet(godo.Droplet{
+				ID: 45678903, Name: "legacy", Tags: []string{"legacy"}, Created: "2020-01-01T00:00:00Z",
+			})
+			fakeProvider.AddFloatingIP("1.2.3.19", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-droplet-tag",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP:         "1.2.3.19",
+					Target:             digitaloceanv1beta1.DropletTag,
+					DropletTag:         "bastion",
+					NodeSelectorPolicy: digitaloceanv1beta1.Oldest,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the floating ip is assigned to the oldest tagged droplet")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.19") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(45678901))
+			Eventually(
+				func() []digitaloceanv1beta1.Candidate {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return binding.Status.Candidates
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(HaveLen(2))
+		})
+	})
+
+	Describe("when the floating ip is assigned to a node", func() {
+		It("should label, annotate and taint the node until the binding is deleted", func() {
+
+			By("Adding a node")
+			ingress := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "ingress",
+					Labels: map[string]string{"role": "ingress"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://56789012"},
+			}
+			Expect(k8sClient.Create(ctx, &ingress)).Should(Succeed(), "failed to create test node")
+
+			By("Adding droplet and floating ip")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 56789012, Name: "ingress"})
+			fakeProvider.AddFloatingIP("1.2.3.20", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-node-labels",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.20",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "ingress"},
+					},
+					NodeTaintEffect: v1.TaintEffectNoSchedule,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the node is labelled, annotated and tainted")
+			labelKey := digitaloceanv1beta1.NodeLabelPrefix + key.Namespace + "." + key.Name
+			node := &v1.Node{}
+			Eventually(
+				func() string {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "ingress"}, node)).Should(Succeed(), "failed to get node")
+					return node.Labels[labelKey]
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal("true"))
+			Expect(node.Annotations[labelKey]).To(Equal("1.2.3.20"))
+			Expect(node.Spec.Taints).To(ContainElement(v1.Taint{
+				Key: labelKey, Value: "true", Effect: v1.TaintEffectNoSchedule,
+			}))
+
+			By("Deleting the binding")
+			Expect(k8sClient.Delete(ctx, binding)).Should(Succeed(), "failed to delete test binding")
+
+			By("Checking the node is no longer labelled")
+			Eventually(
+				func() map[string]string {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "ingress"}, node)).Should(Succeed(), "failed to get node")
+					return node.Labels
+				},
+				time.Second*1, time.Millisecond*100,
+			).ShouldNot(HaveKey(labelKey))
+			Expect(node.Spec.Taints).To(BeEmpty())
+		})
+	})
+
+	Describe("when the floating ip is assigned", func() {
+		It("should record the droplet's anchor network for egress", func() {
+
+			By("Adding a node")
+			egress := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "egress",
+					Labels: map[string]string{"role": "egress"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://67890123"},
+			}
+			Expect(k8sClient.Create(ctx, &egress)).Should(Succeed(), "failed to create test node")
+
+			By("Adding droplet with an anchor network and floating ip")
+			fakeProvider.AddDroplet(godo.Droplet{
+				ID:   67890123,
+				Name: "egress",
+				Networks: &godo.Networks{V4: []godo.NetworkV4{
+					{IPAddress: "203.0.113.10", Netmask: "255.255.240.0", Gateway: "203.0.113.1", Type: "public"},
+					{IPAddress: "10.19.0.5", Netmask: "255.255.0.0", Gateway: "10.19.0.1", Type: "public"},
+					{IPAddress: "10.110.0.2", Netmask: "255.255.240.0", Type: "private"},
+				}},
+			})
+			fakeProvider.AddFloatingIP("1.2.3.21", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-anchor",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.21",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "egress"},
+					},
+					Egress: &digitaloceanv1beta1.Egress{
+						SourceCIDRs: []string{"10.244.0.0/16"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the anchor network is in status")
+			Eventually(
+				func() *digitaloceanv1beta1.Anchor {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return binding.Status.Anchor
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(&digitaloceanv1beta1.Anchor{
+				DropletID: 67890123,
+				IPAddress: "10.19.0.5",
+				Gateway:   "10.19.0.1",
+				Netmask:   "255.255.0.0",
+			}))
+
+			By("Checking egress is waiting for the node agent")
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			Expect(meta.IsStatusConditionPresentAndEqual(
+				binding.Status.Conditions, digitaloceanv1beta1.ConditionEgressReady, metav1.ConditionUnknown,
+			)).To(BeTrue())
+		})
+	})
+
+	Describe("when the binding has a DNS record", func() {
+		It("should keep the record pointing at the floating ip and release it on deletion", func() {
+
+			By("Adding a node")
+			web := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "web",
+					Labels: map[string]string{"role": "web"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://78901234"},
+			}
+			Expect(k8sClient.Create(ctx, &web)).Should(Succeed(), "failed to create test node")
+
+			By("Adding droplet, domain and floating ip")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 78901234, Name: "web"})
+			fakeProvider.AddDomain("example.com")
+			fakeProvider.AddFloatingIP("1.2.3.22", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-dns",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.22",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "web"},
+					},
+					DNS: &digitaloceanv1beta1.DNS{
+						Domain: "example.com",
+						Name:   "www",
+						TTL:    60,
+					},
+					DeletionPolicy: digitaloceanv1beta1.Release,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			records := func() []godo.DomainRecord {
+				records, err := fakeProvider.ListDomainRecords(ctx, "example.com")
+				Expect(err).NotTo(HaveOccurred())
+				return records
+			}
+
+			By("Checking the record is created")
+			Eventually(records, time.Second*1, time.Millisecond*100).Should(HaveLen(1))
+			record := records()[0]
+			Expect(record.Type).To(Equal("A"))
+			Expect(record.Name).To(Equal("www"))
+			Expect(record.Data).To(Equal("1.2.3.22"))
+			Expect(record.TTL).To(Equal(60))
+
+			By("Changing the record outside the controller")
+			_, err := fakeProvider.EditDomainRecord(ctx, "example.com", record.ID, &godo.DomainRecordEditRequest{
+				Data: "198.51.100.1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			binding.Annotations = map[string]string{"test": "drift"}
+			Expect(k8sClient.Update(ctx, binding)).Should(Succeed(), "failed to update binding")
+
+			By("Checking the record is repaired")
+			Eventually(
+				func() string { return records()[0].Data },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal("1.2.3.22"))
+
+			By("Deleting the binding")
+			Expect(k8sClient.Delete(ctx, binding)).Should(Succeed(), "failed to delete test binding")
+
+			By("Checking the record is deleted and the floating ip unassigned")
+			Eventually(records, time.Second*1, time.Millisecond*100).Should(BeEmpty())
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.22") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(0))
+			Eventually(
+				func() bool { return apierrors.IsNotFound(k8sClient.Get(ctx, key, binding)) },
+				time.Second*1, time.Millisecond*100,
+			).Should(BeTrue())
+		})
+	})
+
+	Describe("when the binding has a firewall", func() {
+		It("should move the firewall membership with the floating ip", func() {
+
+			By("Adding nodes")
+			edgeA := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "edge-a",
+					Labels: map[string]string{"role": "edge-a"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://89012345"},
+			}
+			edgeB := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "edge-b",
+					Labels: map[string]string{"role": "edge-b"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://89012346"},
+			}
+			Expect(k8sClient.Create(ctx, &edgeA)).Should(Succeed(), "failed to create test node")
+			Expect(k8sClient.Create(ctx, &edgeB)).Should(Succeed(), "failed to create test node")
+
+			By("Adding droplets, firewall and floating ip")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012345, Name: "edge-a"})
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012346, Name: "edge-b"})
+			fakeProvider.AddFirewall("fw-edge")
+			fakeProvider.AddFloatingIP("1.2.3.23", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-firewall",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.23",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "edge-a"},
+					},
+					FirewallID: "fw-edge",
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			firewallDroplets := func() []int {
+				firewall, err := fakeProvider.GetFirewall(ctx, "fw-edge")
+				Expect(err).NotTo(HaveOccurred())
+				return firewall.DropletIDs
+			}
+
+			By("Checking the droplet is added to the firewall")
+			Eventually(firewallDroplets, time.Second*1, time.Millisecond*100).Should(Equal([]int{89012345}))
+
+			By("Moving the floating ip to the other node")
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			binding.Spec.NodeSelector.MatchLabels["role"] = "edge-b"
+			Expect(k8sClient.Update(ctx, binding)).Should(Succeed(), "failed to update binding")
+
+			By("Checking the firewall only contains the new droplet")
+			Eventually(firewallDroplets, time.Second*1, time.Millisecond*100).Should(Equal([]int{89012346}))
+			Eventually(
+				func() bool {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return meta.IsStatusConditionTrue(binding.Status.Conditions, digitaloceanv1beta1.ConditionFirewallReady)
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(BeTrue())
+		})
+
+		It("should leave droplets it didn't add in the firewall", func() {
+
+			By("Adding nodes")
+			for i, name := range []string{"fw-a", "fw-b"} {
+				node := v1.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   name,
+						Labels: map[string]string{"role": name},
+					},
+					Spec: v1.NodeSpec{ProviderID: fmt.Sprintf("digitalocean://%d", 89012600+i)},
+				}
+				Expect(k8sClient.Create(ctx, &node)).Should(Succeed(), "failed to create test node")
+				fakeProvider.AddDroplet(godo.Droplet{ID: 89012600 + i, Name: name})
+			}
+
+			By("Adding a firewall which already contains the first droplet")
+			fakeProvider.AddFirewall("fw-shared")
+			Expect(fakeProvider.AddDropletsToFirewall(ctx, "fw-shared", 89012600)).To(Succeed())
+			fakeProvider.AddFloatingIP("1.2.3.9", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-firewall-existing",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.9",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "fw-a"},
+					},
+					FirewallID: "fw-shared",
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+			Eventually(
+				func() bool {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					return meta.IsStatusConditionTrue(binding.Status.Conditions, digitaloceanv1beta1.ConditionFirewallReady)
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			Expect(binding.Status.Firewall).To(BeNil())
+
+			By("Moving the floating ip to the other node")
+			binding.Spec.NodeSelector.MatchLabels["role"] = "fw-b"
+			Expect(k8sClient.Update(ctx, binding)).Should(Succeed(), "failed to update binding")
+
+			By("Checking the first droplet is still in the firewall")
+			firewallDroplets := func() []int {
+				firewall, err := fakeProvider.GetFirewall(ctx, "fw-shared")
+				Expect(err).NotTo(HaveOccurred())
+				return firewall.DropletIDs
+			}
+			Eventually(firewallDroplets, time.Second*1, time.Millisecond*100).Should(ConsistOf(89012600, 89012601))
+			Consistently(firewallDroplets, time.Millisecond*500, time.Millisecond*100).Should(ConsistOf(89012600, 89012601))
+		})
+	})
+
+	Describe("when a notifier selects the binding", func() {
+		It("should POST a signed notification when the floating ip is assigned", func() {
+
+			By("Running a webhook receiver")
+			received := make(chan *http.Request, 10)
+			bodies := make(chan []byte, 10)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				received <- req
+				bodies <- body
+			}))
+			defer receiver.Close()
+
+			By("Adding a node, droplet and floating ip")
+			alerting := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "alerting",
+					Labels: map[string]string{"role": "alerting"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://90123456"},
+			}
+			Expect(k8sClient.Create(ctx, &alerting)).Should(Succeed(), "failed to create test node")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 90123456, Name: "alerting"})
+			fakeProvider.AddFloatingIP("1.2.3.24", 0)
+
+			By("Creating a notifier")
+			secret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "default"},
+				Data:       map[string][]byte{"hmac-key": []byte("s3cret")},
+			}
+			Expect(k8sClient.Create(ctx, secret)).Should(Succeed(), "failed to create test secret")
+			notifier := &digitaloceanv1beta1.FloatingIPNotifier{
+				ObjectMeta: metav1.ObjectMeta{Name: "on-call", Namespace: "default"},
+				Spec: digitaloceanv1beta1.FloatingIPNotifierSpec{
+					URL: receiver.URL,
+					BindingSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"notify": "true"},
+					},
+					Events:    []digitaloceanv1beta1.NotificationEvent{digitaloceanv1beta1.NotifyAssigned},
+					SecretRef: &digitaloceanv1beta1.SecretKeyReference{Name: "webhook", Key: "hmac-key"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, notifier)).Should(Succeed(), "failed to create test notifier")
+
+			By("Creating a binding")
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "floatingipbinding-notify",
+					Namespace: "default",
+					Labels:    map[string]string{"notify": "true"},
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.24",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "alerting"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the notification is received")
+			var req *http.Request
+			Eventually(received, time.Second*2).Should(Receive(&req))
+			body := <-bodies
+			Expect(req.Header.Get(notify.SignatureHeader)).To(Equal(notify.Sign([]byte("s3cret"), body)))
+			var payload notify.Payload
+			Expect(json.Unmarshal(body, &payload)).To(Succeed())
+			Expect(payload.Event).To(Equal("Assigned"))
+			Expect(payload.Binding.Name).To(Equal("floatingipbinding-notify"))
+			Expect(payload.FloatingIP).To(Equal("1.2.3.24"))
+			Expect(payload.NewDroplet).To(Equal(&notify.Droplet{ID: 90123456, Name: "alerting"}))
+		})
+	})
+
+	Describe("when the floating IP doesn't exist", func() {
+		It("should stall the binding until its spec changes", func() {
+
+			By("Adding a node and droplet without the floating ip")
+			stalled := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "stalled",
+					Labels: map[string]string{"role": "stalled"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://91234567"},
+			}
+			Expect(k8sClient.Create(ctx, &stalled)).Should(Succeed(), "failed to create test node")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 91234567, Name: "stalled"})
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-stalled",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.25",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "stalled"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			stalledCondition := func() *metav1.Condition {
+				binding := &digitaloceanv1beta1.FloatingIPBinding{}
+				Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+				return meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionStalled)
+			}
+
+			By("Checking the binding is stalled")
+			Eventually(
+				func() metav1.ConditionStatus {
+					if condition := stalledCondition(); condition != nil {
+						return condition.Status
+					}
+					return ""
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(metav1.ConditionTrue), "Stalled condition should be True")
+
+			By("Creating the floating ip and changing the spec")
+			fakeProvider.AddFloatingIP("1.2.3.25", 0)
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			binding.Spec.TakeoverPolicy = digitaloceanv1beta1.Always
+			Expect(k8sClient.Update(ctx, binding)).Should(Succeed(), "failed to update binding")
+
+			By("Checking the floating ip is assigned")
+			Eventually(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.25") },
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(91234567))
+			Eventually(stalledCondition, time.Second*1, time.Millisecond*100).Should(BeNil())
+		})
+	})
+
+	Describe("when spec.floatingIP changes", func() {
+		It("should release the previous floating ip with Release", func() {
+
+			By("Adding a node, droplet and two floating ips")
+			changing := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "changing",
+					Labels: map[string]string{"role": "changing"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://92345678"},
+			}
+			Expect(k8sClient.Create(ctx, &changing)).Should(Succeed(), "failed to create test node")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 92345678, Name: "changing"})
+			fakeProvider.AddFloatingIP("1.2.3.26", 0)
+			fakeProvider.AddFloatingIP("5.6.7.8", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-changing",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.26",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "changing"},
+					},
+					DeletionPolicy: digitaloceanv1beta1.Release,
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			managedIP := func() string {
+				binding := &digitaloceanv1beta1.FloatingIPBinding{}
+				Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+				return binding.Status.ManagedIP
+			}
+			Eventually(managedIP, time.Second*1, time.Millisecond*100).Should(Equal("1.2.3.26"))
+			Expect(fakeProvider.AssignedDropletID("1.2.3.26")).To(Equal(92345678))
+
+			By("Changing the floating ip")
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			binding.Spec.FloatingIP = "5.6.7.8"
+			Expect(k8sClient.Update(ctx, binding)).Should(Succeed(), "failed to update binding")
+
+			By("Checking the previous floating ip was released")
+			Eventually(managedIP, time.Second*1, time.Millisecond*100).Should(Equal("5.6.7.8"))
+			Expect(fakeProvider.AssignedDropletID("5.6.7.8")).To(Equal(92345678))
+			Expect(fakeProvider.AssignedDropletID("1.2.3.26")).To(BeZero())
+		})
+	})
+
+	Describe("when the binding has another controllerName", func() {
+		It("should leave the floating ip alone and report the binding as unclaimed", func() {
+
+			By("Adding a node, droplet and floating ip")
+			other := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "other-class",
+					Labels: map[string]string{"role": "other-class"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://93456789"},
+			}
+			Expect(k8sClient.Create(ctx, &other)).Should(Succeed(), "failed to create test node")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 93456789, Name: "other-class"})
+			fakeProvider.AddFloatingIP("1.2.3.27", 0)
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-other-class",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.27",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "other-class"},
+					},
+					ControllerName: "example.com/other",
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the binding is reported as unclaimed")
+			Eventually(
+				func() string {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionClaimed)
+					if condition == nil || condition.Status != metav1.ConditionFalse {
+						return ""
+					}
+					return condition.Reason
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal("NoController"), "Claimed condition should be False")
+			Consistently(
+				func() int { return fakeProvider.AssignedDropletID("1.2.3.27") },
+				time.Millisecond*500, time.Millisecond*100,
+			).Should(BeZero())
+		})
+	})
+
+	Describe("when a claim references a floating IP class", func() {
+		It("should bind a floating IP from the class and create a binding", func() {
+			By("Creating a class")
+			class := &digitaloceanv1beta1.FloatingIPClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "floatingipclass-pool"},
+				Spec: digitaloceanv1beta1.FloatingIPClassSpec{
+					FloatingIPs:       []string{"123.10.10.50"},
+					AllowedNamespaces: []string{"default"},
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "claim"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, class)).Should(Succeed(), "failed to create test class")
+
+			By("Creating a claim")
+			key := client.ObjectKey{
+				Name:      "floatingipclaim-pool",
+				Namespace: "default",
+			}
+			claim := &digitaloceanv1beta1.FloatingIPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPClaimSpec{
+					ClassName: class.Name,
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"pool": "web"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, claim)).Should(Succeed(), "failed to create test claim")
+
+			By("Checking the claim is bound")
+			Eventually(
+				func() digitaloceanv1beta1.ClaimPhase {
+					claim := &digitaloceanv1beta1.FloatingIPClaim{}
+					Expect(k8sClient.Get(ctx, key, claim)).Should(Succeed(), "failed to get claim")
+					return claim.Status.Phase
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal(digitaloceanv1beta1.ClaimBound), "claim should be bound")
+
+			By("Checking the binding was created for the claim")
+			binding := &digitaloceanv1beta1.FloatingIPBinding{}
+			Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+			Expect(binding.Spec.FloatingIP).Should(Equal("123.10.10.50"))
+			Expect(binding.Spec.ClassName).Should(Equal(class.Name))
+			Expect(binding.Spec.DeletionPolicy).Should(Equal(digitaloceanv1beta1.Release))
+			Expect(binding.Spec.NodeSelector.MatchLabels).Should(Equal(map[string]string{"role": "claim", "pool": "web"}))
+			Expect(binding.OwnerReferences).Should(HaveLen(1))
+			Expect(binding.OwnerReferences[0].Name).Should(Equal(key.Name))
+		})
+	})
+
+	Describe("when the binding references a service", func() {
+		It("should publish the floating ip in the service status until the binding is deleted", func() {
+
+			By("Adding a node, droplet and floating ip")
+			node := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "svc-a",
+					Labels: map[string]string{"role": "svc-a"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://89012400"},
+			}
+			Expect(k8sClient.Create(ctx, &node)).Should(Succeed(), "failed to create test node")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012400, Name: "svc-a"})
+			fakeProvider.AddFloatingIP("1.2.3.28", 0)
+
+			By("Creating a service")
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "ingress",
+					Namespace: "default",
+				},
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "http", Port: 80}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, service)).Should(Succeed(), "failed to create test service")
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-service",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.28",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "svc-a"},
+					},
+					ServiceRef: &digitaloceanv1beta1.ServiceReference{Name: service.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			serviceIngress := func() []v1.LoadBalancerIngress {
+				service := &v1.Service{}
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "ingress", Namespace: "default"}, service)).Should(Succeed(), "failed to get service")
+				return service.Status.LoadBalancer.Ingress
+			}
+
+			By("Checking the floating ip is published in the service status")
+			Eventually(serviceIngress, time.Second*1, time.Millisecond*100).Should(Equal([]v1.LoadBalancerIngress{{IP: "1.2.3.28"}}))
+
+			By("Deleting the binding")
+			Expect(k8sClient.Delete(ctx, binding)).Should(Succeed(), "failed to delete binding")
+
+			By("Checking the floating ip is removed from the service status")
+			Eventually(serviceIngress, time.Second*1, time.Millisecond*100).Should(BeEmpty())
+			Eventually(
+				func() bool {
+					err := k8sClient.Get(ctx, key, &digitaloceanv1beta1.FloatingIPBinding{})
+					return apierrors.IsNotFound(err)
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(BeTrue(), "binding should be deleted")
+			Expect(fakeProvider.AssignedDropletID("1.2.3.28")).To(Equal(89012400), "Retain should leave the floating ip assigned")
+		})
+
+		It("should leave a service owned by another load balancer controller alone", func() {
+
+			By("Adding a node, droplet and floating ip")
+			node := v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "svc-b",
+					Labels: map[string]string{"role": "svc-b"},
+				},
+				Spec: v1.NodeSpec{ProviderID: "digitalocean://89012401"},
+			}
+			Expect(k8sClient.Create(ctx, &node)).Should(Succeed(), "failed to create test node")
+			fakeProvider.AddDroplet(godo.Droplet{ID: 89012401, Name: "svc-b"})
+			fakeProvider.AddFloatingIP("1.2.3.10", 0)
+
+			By("Creating a load balancer service without a loadBalancerClass")
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cloud-lb",
+					Namespace: "default",
+				},
+				Spec: v1.ServiceSpec{
+					Type:  v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{{Name: "http", Port: 80}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, service)).Should(Succeed(), "failed to create test service")
+
+			By("Creating a binding")
+			key := client.ObjectKey{
+				Name:      "floatingipbinding-cloud-lb",
+				Namespace: "default",
+			}
+			binding := &digitaloceanv1beta1.FloatingIPBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: digitaloceanv1beta1.FloatingIPBindingSpec{
+					FloatingIP: "1.2.3.10",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "svc-b"},
+					},
+					ServiceRef: &digitaloceanv1beta1.ServiceReference{Name: service.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed(), "failed to create test binding")
+
+			By("Checking the service is reported as not owned")
+			Eventually(
+				func() string {
+					binding := &digitaloceanv1beta1.FloatingIPBinding{}
+					Expect(k8sClient.Get(ctx, key, binding)).Should(Succeed(), "failed to get binding")
+					condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionServiceReady)
+					if condition == nil || condition.Status != metav1.ConditionFalse {
+						return ""
+					}
+					return condition.Reason
+				},
+				time.Second*1, time.Millisecond*100,
+			).Should(Equal("ServiceNotOwned"))
+			Expect(fakeProvider.AssignedDropletID("1.2.3.10")).To(Equal(89012401), "the floating ip should still be assigned")
+
+			By("Checking the floating ip isn't published in the service status")
+			latest := &v1.Service{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(service), latest)).Should(Succeed(), "failed to get service")
+			Expect(latest.Status.LoadBalancer.Ingress).Should(BeEmpty())
+		})
+	})
 })
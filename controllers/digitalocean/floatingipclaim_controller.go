@@ -0,0 +1,293 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// FloatingIPClaimReconciler binds FloatingIPClaims to a floating IP from their
+// FloatingIPClass and creates a FloatingIPBinding for each claim
+type FloatingIPClaimReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Provider is used for classes without a CredentialsRef
+	Provider *ProviderHolder
+	Recorder record.EventRecorder
+	// ProviderCache holds providers built from each class's CredentialsRef
+	ProviderCache ProviderCache
+	// APIReader reads Secrets, and the classes, claims and bindings floating IPs are
+	// allocated from, straight from the API server. The cache may lag behind or, with
+	// --watch-namespaces, not hold every claim. Defaults to the Client
+	APIReader client.Reader
+	// ControllerName selects the classes managed by this instance.
+	// Defaults to DefaultControllerName
+	ControllerName string
+	// RequeueInterval is how often pending claims are retried. Defaults to RequeueAfter
+	RequeueInterval time.Duration
+
+	// unrecorded holds reserved floating IPs until they are recorded on their class
+	unrecorded unrecordedIPs
+}
+
+//+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipclaims,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipclaims/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipclasses/status,verbs=get;update;patch
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FloatingIPClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&digitaloceanv1beta1.FloatingIPClaim{}).
+		Owns(&digitaloceanv1beta1.FloatingIPBinding{}).
+		Watches(
+			&source.Kind{Type: &digitaloceanv1beta1.FloatingIPClass{}},
+			handler.EnqueueRequestsFromMapFunc(r.classToRequests),
+		).
+		Complete(r)
+}
+
+func (r *FloatingIPClaimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("floatingipclaim", req.NamespacedName)
+
+	claim := &digitaloceanv1beta1.FloatingIPClaim{}
+	if err := r.Get(ctx, req.NamespacedName, claim); err != nil {
+		if apierrors.IsNotFound(err) {
+			// The binding is garbage collected with the claim
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get FloatingIPClaim")
+		return ctrl.Result{}, err
+	}
+	if !claim.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	class := &digitaloceanv1beta1.FloatingIPClass{}
+	if err := r.Get(ctx, types.NamespacedName{Name: claim.Spec.ClassName}, class); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.pending(ctx, log, claim, "ClassNotFound",
+				fmt.Sprintf("FloatingIPClass %s does not exist", claim.Spec.ClassName))
+		}
+		log.Error(err, "Failed to get FloatingIPClass")
+		return ctrl.Result{}, err
+	}
+	// Leave classes of other controller instances to them
+	if classControllerName(class) != r.controllerName() {
+		return ctrl.Result{}, nil
+	}
+	if !class.AllowsNamespace(claim.Namespace) {
+		return r.pending(ctx, log, claim, "NamespaceNotAllowed",
+			fmt.Sprintf("FloatingIPClass %s does not allow claims in namespace %s", class.Name, claim.Namespace))
+	}
+	selector, err := MergeSelectors(class.Spec.NodeSelector, claim.Spec.NodeSelector)
+	if err != nil {
+		return r.pending(ctx, log, claim, "InvalidNodeSelector", err.Error())
+	}
+
+	// Bind the claim to a floating IP once, recording it before the binding is created
+	if claim.Status.FloatingIP == "" {
+		ip, reason, err := r.bindIP(ctx, log, class, claim)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if ip == "" {
+			return r.pending(ctx, log, claim, reason,
+				fmt.Sprintf("FloatingIPClass %s has no floating IP available", class.Name))
+		}
+		claim.Status.FloatingIP = ip
+		if err := r.Status().Update(ctx, claim); err != nil {
+			log.Error(err, "Failed to update status")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(claim, v1.EventTypeNormal, "Bound", "Bound to floating IP %s", ip)
+	} else if err := r.recordAllocation(ctx, log, class.Name, claim); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create the binding, without adopting a binding the claim doesn't own
+	binding := &digitaloceanv1beta1.FloatingIPBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: claim.Name, Namespace: claim.Namespace},
+	}
+	err = r.Get(ctx, client.ObjectKeyFromObject(binding), binding)
+	if err == nil && !metav1.IsControlledBy(binding, claim) {
+		return r.pending(ctx, log, claim, "BindingExists",
+			fmt.Sprintf("FloatingIPBinding %s already exists and is not owned by the claim", binding.Name))
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get FloatingIPBinding")
+		return ctrl.Result{}, err
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, binding, func() error {
+		binding.Spec.FloatingIP = claim.Status.FloatingIP
+		binding.Spec.NodeSelector = selector
+		binding.Spec.ClassName = class.Name
+		binding.Spec.ControllerName = class.Spec.ControllerName
+		binding.Spec.DeletionPolicy = class.Spec.DeletionPolicy
+		if binding.Spec.DeletionPolicy == "" {
+			binding.Spec.DeletionPolicy = digitaloceanv1beta1.Release
+		}
+		return controllerutil.SetControllerReference(claim, binding, r.Scheme)
+	})
+	if err != nil {
+		log.Error(err, "Failed to create or update FloatingIPBinding")
+		return ctrl.Result{}, err
+	}
+	if op != controllerutil.OperationResultNone {
+		log.Info("Reconciled FloatingIPBinding", "operation", op)
+	}
+
+	// Report the binding's progress on the claim
+	status := claim.Status.DeepCopy()
+	claim.Status.Phase = digitaloceanv1beta1.ClaimBound
+	claim.Status.BindingName = binding.Name
+	claim.Status.AssignedDropletName = binding.Status.AssignedDropletName
+	meta.SetStatusCondition(&claim.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionBound,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: claim.Generation,
+		Reason:             "Bound",
+		Message:            fmt.Sprintf("Bound to floating IP %s", claim.Status.FloatingIP),
+	})
+	if !equality.Semantic.DeepEqual(status, &claim.Status) {
+		if err := r.Status().Update(ctx, claim); err != nil {
+			log.Error(err, "Failed to update status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// pending records why the claim can't be bound and retries it later
+func (r *FloatingIPClaimReconciler) pending(
+	ctx context.Context,
+	log logr.Logger,
+	claim *digitaloceanv1beta1.FloatingIPClaim,
+	reason string,
+	message string,
+) (ctrl.Result, error) {
+	log.Info("Claim is pending", "reason", reason, "message", message)
+	condition := meta.FindStatusCondition(claim.Status.Conditions, digitaloceanv1beta1.ConditionBound)
+	if condition == nil || condition.Reason != reason {
+		r.Recorder.Event(claim, v1.EventTypeWarning, reason, message)
+	}
+	if claim.Status.Phase == "" {
+		claim.Status.Phase = digitaloceanv1beta1.ClaimPending
+	}
+	meta.SetStatusCondition(&claim.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionBound,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: claim.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	if err := r.Status().Update(ctx, claim); err != nil {
+		log.Error(err, "Failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: r.requeueInterval()}, nil
+}
+
+// MergeSelectors returns a LabelSelector matching both selectors. Either may be nil.
+func MergeSelectors(a, b *metav1.LabelSelector) (*metav1.LabelSelector, error) {
+	if a == nil {
+		return b.DeepCopy(), nil
+	}
+	if b == nil {
+		return a.DeepCopy(), nil
+	}
+	merged := a.DeepCopy()
+	for key, value := range b.MatchLabels {
+		if existing, ok := merged.MatchLabels[key]; ok && existing != value {
+			return nil, fmt.Errorf("nodeSelector requires %s=%s but the class requires %s=%s", key, value, key, existing)
+		}
+		if merged.MatchLabels == nil {
+			merged.MatchLabels = map[string]string{}
+		}
+		merged.MatchLabels[key] = value
+	}
+	merged.MatchExpressions = append(merged.MatchExpressions, b.DeepCopy().MatchExpressions...)
+	return merged, nil
+}
+
+// classControllerName returns the controllerName of the instance which manages the class
+func classControllerName(class *digitaloceanv1beta1.FloatingIPClass) string {
+	if class.Spec.ControllerName == "" {
+		return digitaloceanv1beta1.DefaultControllerName
+	}
+	return class.Spec.ControllerName
+}
+
+// controllerName returns the controllerName of this instance
+func (r *FloatingIPClaimReconciler) controllerName() string {
+	if r.ControllerName == "" {
+		return digitaloceanv1beta1.DefaultControllerName
+	}
+	return r.ControllerName
+}
+
+// requeueInterval returns how long to wait before retrying a pending claim
+func (r *FloatingIPClaimReconciler) requeueInterval() time.Duration {
+	if r.RequeueInterval > 0 {
+		return r.RequeueInterval
+	}
+	return RequeueAfter
+}
+
+func (r *FloatingIPClaimReconciler) classToRequests(class client.Object) []reconcile.Request {
+	// Reconcile every claim of the class when it changes
+	var claims digitaloceanv1beta1.FloatingIPClaimList
+	if err := r.List(context.Background(), &claims); err != nil {
+		r.Log.Error(err, "Failed to list floating IP claims")
+		return []reconcile.Request{}
+	}
+
+	var reconcileRequests []reconcile.Request
+	for _, claim := range claims.Items {
+		if claim.Spec.ClassName != class.GetName() {
+			continue
+		}
+		reconcileRequests = append(reconcileRequests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      claim.GetName(),
+				Namespace: claim.GetNamespace(),
+			},
+		})
+	}
+	return reconcileRequests
+}
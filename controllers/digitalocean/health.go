@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+const (
+	// DefaultAPIHealthWindow is how long a failed DigitalOcean API call counts against readiness
+	DefaultAPIHealthWindow = 5 * time.Minute
+	// DefaultAPIFailureThreshold is how many consecutive failed calls make the controller unready
+	DefaultAPIFailureThreshold = 3
+)
+
+// APIHealth tracks the outcome of recent DigitalOcean API calls for the readyz check.
+// Calls made with the global token report through ObserveAccount so that an
+// authentication error, such as a revoked token, makes the controller unready.
+// Calls made with a binding's CredentialsRef report through ObserveBinding, where
+// authentication errors only affect that binding.
+type APIHealth struct {
+	// Window is how long a failure is remembered. Defaults to DefaultAPIHealthWindow
+	Window time.Duration
+	// FailureThreshold is how many consecutive failures make the check fail.
+	// Defaults to DefaultAPIFailureThreshold
+	FailureThreshold int
+
+	mu          sync.Mutex
+	failures    int
+	lastFailure time.Time
+	lastErr     error
+	authErr     error
+}
+
+// ObserveAccount records the outcome of a call made with the global token
+func (h *APIHealth) ObserveAccount(err error) {
+	h.observe(err, true)
+}
+
+// ObserveBinding records the outcome of a call made with a binding's credentials
+func (h *APIHealth) ObserveBinding(err error) {
+	h.observe(err, false)
+}
+
+func (h *APIHealth) observe(err error, account bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case isAuthError(err):
+		if account {
+			h.authErr = err
+		}
+		// The API answered, so it is reachable
+		h.failures = 0
+	case isUnavailable(err):
+		h.failures++
+		h.lastFailure = time.Now()
+		h.lastErr = err
+	case errors.Is(err, context.Canceled):
+		// Cancelled calls say nothing about the API
+	default:
+		if account {
+			h.authErr = nil
+		}
+		h.failures = 0
+	}
+}
+
+// Checker is a readyz check which fails after an authentication error with the global
+// token, or when the most recent calls to the DigitalOcean API have all failed
+func (h *APIHealth) Checker(_ *http.Request) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.authErr != nil {
+		return fmt.Errorf("DigitalOcean rejected the token: %w", h.authErr)
+	}
+	window := h.Window
+	if window == 0 {
+		window = DefaultAPIHealthWindow
+	}
+	threshold := h.FailureThreshold
+	if threshold == 0 {
+		threshold = DefaultAPIFailureThreshold
+	}
+	if h.failures >= threshold && time.Since(h.lastFailure) < window {
+		return fmt.Errorf("last %d DigitalOcean API calls failed: %w", h.failures, h.lastErr)
+	}
+	return nil
+}
+
+// isAuthError returns true when the API rejected the credentials
+func isAuthError(err error) bool {
+	return provider.HasStatus(err, http.StatusUnauthorized) || provider.HasStatus(err, http.StatusForbidden)
+}
+
+// isUnavailable returns true when the API couldn't be reached or failed to handle the call
+func isUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *provider.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
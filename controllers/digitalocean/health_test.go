@@ -0,0 +1,160 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+func TestAPIHealthChecker(t *testing.T) {
+	unauthorized := &provider.StatusError{StatusCode: http.StatusUnauthorized, Message: "Unable to authenticate you"}
+	forbidden := &provider.StatusError{StatusCode: http.StatusForbidden, Message: "You are not authorized"}
+	unavailable := &provider.StatusError{StatusCode: http.StatusServiceUnavailable, Message: "Service Unavailable"}
+	rateLimited := &provider.StatusError{StatusCode: http.StatusTooManyRequests, Message: "Too many requests"}
+	notFound := &provider.StatusError{StatusCode: http.StatusNotFound, Message: "Not found"}
+	unreachable := errors.New("dial tcp: connection refused")
+
+	type call struct {
+		err     error
+		account bool
+	}
+	tests := []struct {
+		name    string
+		calls   []call
+		expired bool
+		wantErr bool
+	}{
+		{
+			name: "no calls",
+		},
+		{
+			name:    "account auth error",
+			calls:   []call{{err: unauthorized, account: true}},
+			wantErr: true,
+		},
+		{
+			name:    "account forbidden",
+			calls:   []call{{err: fmt.Errorf("get floating IP: %w", forbidden), account: true}},
+			wantErr: true,
+		},
+		{
+			name:  "binding auth error",
+			calls: []call{{err: unauthorized}},
+		},
+		{
+			name:  "account recovers after auth error",
+			calls: []call{{err: unauthorized, account: true}, {account: true}},
+		},
+		{
+			name:    "binding success doesn't clear account auth error",
+			calls:   []call{{err: unauthorized, account: true}, {}},
+			wantErr: true,
+		},
+		{
+			name:  "below threshold",
+			calls: []call{{err: unavailable}, {err: unreachable}},
+		},
+		{
+			name:    "threshold reached",
+			calls:   []call{{err: unavailable}, {err: rateLimited}, {err: unreachable}},
+			wantErr: true,
+		},
+		{
+			name:  "success resets failures",
+			calls: []call{{err: unavailable}, {err: unavailable}, {}, {err: unavailable}},
+		},
+		{
+			name:  "client errors reset failures",
+			calls: []call{{err: unavailable}, {err: unavailable}, {err: notFound}, {err: unavailable}},
+		},
+		{
+			name:  "auth errors show the API is reachable",
+			calls: []call{{err: unavailable}, {err: unavailable}, {err: unauthorized}, {err: unavailable}},
+		},
+		{
+			name:    "window expired",
+			calls:   []call{{err: unavailable}, {err: unavailable}, {err: unavailable}},
+			expired: true,
+		},
+		{
+			name: "cancelled calls are ignored",
+			calls: []call{
+				{err: unavailable}, {err: unavailable},
+				{err: context.Canceled}, {err: fmt.Errorf("list droplets: %w", context.Canceled)},
+			},
+		},
+		{
+			name: "cancelled calls don't reset failures",
+			calls: []call{
+				{err: unavailable}, {err: unavailable},
+				{err: context.Canceled}, {err: unavailable},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &APIHealth{}
+			for _, c := range tt.calls {
+				if c.account {
+					h.ObserveAccount(c.err)
+				} else {
+					h.ObserveBinding(c.err)
+				}
+			}
+			if tt.expired {
+				h.lastFailure = time.Now().Add(-DefaultAPIHealthWindow)
+			}
+			if err := h.Checker(nil); (err != nil) != tt.wantErr {
+				t.Errorf("Checker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIHealthSettings(t *testing.T) {
+	h := &APIHealth{Window: time.Millisecond * 50, FailureThreshold: 1}
+	h.ObserveBinding(errors.New("connection reset by peer"))
+	if err := h.Checker(nil); err == nil {
+		t.Fatal("Checker() error = nil, want a failure after 1 failed call")
+	}
+	time.Sleep(time.Millisecond * 60)
+	if err := h.Checker(nil); err != nil {
+		t.Errorf("Checker() error = %v after the window, want nil", err)
+	}
+}
+
+func TestAPIHealthObservesProvider(t *testing.T) {
+	fake := provider.NewFake()
+	h := &APIHealth{}
+	observed := provider.WithObserver(fake, h.ObserveAccount)
+
+	fake.Fail("ListFloatingIPs", &provider.StatusError{StatusCode: http.StatusUnauthorized, Message: "Unable to authenticate you"})
+	if _, err := observed.ListFloatingIPs(context.Background()); err == nil {
+		t.Fatal("ListFloatingIPs() error = nil, want the injected error")
+	}
+	if err := h.Checker(nil); err == nil {
+		t.Error("Checker() error = nil, want the token rejected")
+	}
+}
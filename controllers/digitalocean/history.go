@@ -0,0 +1,98 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// HistoryLimit is the number of assignments kept in status.history
+const HistoryLimit = 10
+
+// AssignmentReason works out why the floating IP is being assigned to droplet
+func (r *FloatingIPBindingReconciler) AssignmentReason(
+	ctx context.Context,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	droplet *Droplet,
+) digitaloceanv1beta1.AssignmentReason {
+	status := binding.Status
+	switch {
+	case ReassignRequested(binding):
+		return digitaloceanv1beta1.ReasonManual
+	case status.AssignedDropletID == droplet.ID:
+		// The floating IP should already have been on this droplet
+		pinned := binding.Spec.NodeSelectorPolicy == digitaloceanv1beta1.Random ||
+			(status.LastReassign != nil && status.LastReassign.To != nil && status.LastReassign.To.ID == droplet.ID)
+		if pinned {
+			return digitaloceanv1beta1.ReasonPinned
+		}
+		return digitaloceanv1beta1.ReasonDrift
+	case TargetsDropletTag(binding):
+		// The previous droplet is gone if it no longer has the tag
+		if status.AssignedDropletID != 0 {
+			tagged, err := r.HasDropletTag(ctx, r.Log, binding, status.AssignedDropletID)
+			if err == nil && !tagged {
+				return digitaloceanv1beta1.ReasonNodeGone
+			}
+		}
+	case status.AssignedDropletName != "":
+		node := &v1.Node{}
+		err := r.Get(ctx, types.NamespacedName{Name: status.AssignedDropletName}, node)
+		if apierrors.IsNotFound(err) || (err == nil && node.DeletionTimestamp != nil) {
+			return digitaloceanv1beta1.ReasonNodeGone
+		}
+	}
+	return digitaloceanv1beta1.ReasonPolicy
+}
+
+// RecordAssignment ends the current assignment in the binding's history and adds a
+// new one for droplet, dropping the oldest entries beyond HistoryLimit
+func RecordAssignment(
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	droplet *Droplet,
+	reason digitaloceanv1beta1.AssignmentReason,
+	actionID int,
+) {
+	now := metav1.Now()
+	EndAssignment(binding, now)
+	binding.Status.History = append(binding.Status.History, digitaloceanv1beta1.AssignmentRecord{
+		DropletID:   droplet.ID,
+		DropletName: droplet.Name,
+		Node:        droplet.Node,
+		Start:       now,
+		Reason:      reason,
+		ActionID:    actionID,
+	})
+	if extra := len(binding.Status.History) - HistoryLimit; extra > 0 {
+		binding.Status.History = binding.Status.History[extra:]
+	}
+}
+
+// EndAssignment marks the current assignment in the binding's history as ended
+func EndAssignment(binding *digitaloceanv1beta1.FloatingIPBinding, end metav1.Time) {
+	history := binding.Status.History
+	if len(history) > 0 && history[len(history)-1].End == nil {
+		history[len(history)-1].End = &end
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// ManagedIPChanged returns true when spec.floatingIP no longer matches the floating IP
+// the controller last assigned for the binding
+func ManagedIPChanged(binding *digitaloceanv1beta1.FloatingIPBinding) bool {
+	return binding.Status.ManagedIP != "" && binding.Status.ManagedIP != binding.Spec.FloatingIP
+}
+
+// ReleaseManagedIP applies the DeletionPolicy to the previously managed floating IP when
+// spec.floatingIP has changed. Retain leaves it assigned and Release unassigns it from the
+// droplet the binding assigned it to. The binding's assignment is reset and persisted so the
+// new floating IP is recorded when it is assigned.
+func (r *FloatingIPBindingReconciler) ReleaseManagedIP(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	if !ManagedIPChanged(binding) {
+		return nil
+	}
+	oldIP := binding.Status.ManagedIP
+	log = log.WithValues("previousFloatingIP", oldIP)
+
+	if binding.Spec.DeletionPolicy == digitaloceanv1beta1.Release {
+		switch {
+		case r.DryRunEnabled(binding):
+			log.Info("Dry-run enabled. Skipping release of previous floatingIP.")
+			r.Recorder.Eventf(binding, v1.EventTypeNormal, "DryRun",
+				"Would unassign previous floating IP %s as spec.floatingIP changed", oldIP)
+			return nil
+		case r.Freeze:
+			return ErrFrozen
+		}
+		// Only unassign the previous floating IP from the droplet this binding assigned it to
+		log.Info("spec.floatingIP changed. Releasing previous floatingIP.")
+		err := r.unassignIP(ctx, log, binding, oldIP, assignedByBinding(binding))
+		var conflict *TakeoverConflictError
+		if errors.As(err, &conflict) {
+			log.Info("Leaving previous floatingIP assigned to a droplet this binding didn't assign it to",
+				"currentDropletID", conflict.DropletID, "assignedDropletID", binding.Status.AssignedDropletID)
+			r.Recorder.Eventf(binding, v1.EventTypeNormal, "FloatingIPRetained",
+				"Left previous floating IP %s assigned to droplet %d as this binding didn't assign it there",
+				oldIP, conflict.DropletID)
+		} else if err != nil {
+			return err
+		} else {
+			r.Recorder.Eventf(binding, v1.EventTypeNormal, "FloatingIPReleased",
+				"Unassigned previous floating IP %s as spec.floatingIP changed to %s", oldIP, binding.Spec.FloatingIP)
+		}
+	} else {
+		log.Info("spec.floatingIP changed. Retaining previous floatingIP.")
+		r.Recorder.Eventf(binding, v1.EventTypeNormal, "FloatingIPRetained",
+			"Left previous floating IP %s assigned as spec.floatingIP changed to %s", oldIP, binding.Spec.FloatingIP)
+	}
+
+	// The previous floating IP no longer belongs to the binding
+	if err := r.UnpublishService(ctx, log, binding); err != nil {
+		return err
+	}
+
+	EndAssignment(binding, metav1.Now())
+	binding.Status.ManagedIP = ""
+	binding.Status.AssignedDropletID = 0
+	binding.Status.AssignedDropletName = ""
+	binding.Status.Anchor = nil
+	// Record the reset straight away so the previous floating IP isn't released again
+	// if a later step of the reconcile fails
+	if err := r.Status().Update(ctx, binding); err != nil {
+		log.Error(err, "Failed to update status after releasing previous floatingIP")
+		return err
+	}
+	return nil
+}
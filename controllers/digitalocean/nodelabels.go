@@ -0,0 +1,228 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// NodeLabelKey returns the label, annotation and taint key marking the node holding the
+// floating IP of a binding. The key is named <namespace>.<name> so bindings with the same
+// name in different namespaces don't share it. Names too long for a label key are
+// shortened and suffixed with a hash of the namespace and name.
+func NodeLabelKey(binding types.NamespacedName) (string, error) {
+	name := binding.Namespace + "." + binding.Name
+	if len(name) > validation.LabelValueMaxLength {
+		sum := sha256.Sum256([]byte(binding.String()))
+		suffix := "-" + hex.EncodeToString(sum[:])[:10]
+		name = strings.TrimRight(name[:validation.LabelValueMaxLength-len(suffix)], ".-_") + suffix
+	}
+	key := digitaloceanv1beta1.NodeLabelPrefix + name
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return "", Permanent(fmt.Errorf("invalid node label key %q: %s", key, strings.Join(errs, ", ")))
+	}
+	return key, nil
+}
+
+// LabelNodes marks the named node as holding the binding's floating IP and removes
+// the marks from every other node. An empty nodeName removes them from all nodes.
+func (r *FloatingIPBindingReconciler) LabelNodes(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	nodeName string,
+) error {
+	key, err := NodeLabelKey(client.ObjectKeyFromObject(binding))
+	if err != nil {
+		log.Error(err, "Cannot label nodes")
+		return err
+	}
+
+	var nodes v1.NodeList
+	if err := r.Client.List(ctx, &nodes); err != nil {
+		log.Error(err, "Could not list nodes")
+		return err
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		// Taints are a list which a merge patch replaces, so fail on a conflicting update
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		var changed bool
+		if node.GetName() == nodeName {
+			changed = markNode(node, key, binding.Spec.FloatingIP, binding.Spec.NodeTaintEffect)
+		} else {
+			changed = unmarkNode(node, key)
+		}
+		if !changed {
+			continue
+		}
+		if err := r.Client.Patch(ctx, node, patch); err != nil {
+			log.Error(err, "Failed to update node labels", "node", node.GetName())
+			return err
+		}
+		log.Info("Updated node labels", "node", node.GetName(), "holdsFloatingIP", node.GetName() == nodeName)
+	}
+	return nil
+}
+
+// UnlabelNodes removes the marks of a deleted binding from every node
+func (r *FloatingIPBindingReconciler) UnlabelNodes(ctx context.Context, log logr.Logger, binding types.NamespacedName) error {
+	key, err := NodeLabelKey(binding)
+	if err != nil {
+		return nil
+	}
+
+	var nodes v1.NodeList
+	if err := r.Client.List(ctx, &nodes, client.HasLabels{key}); err != nil {
+		log.Error(err, "Could not list nodes")
+		return err
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		// Taints are a list which a merge patch replaces, so fail on a conflicting update
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if !unmarkNode(node, key) {
+			continue
+		}
+		if err := r.Client.Patch(ctx, node, patch); err != nil {
+			log.Error(err, "Failed to update node labels", "node", node.GetName())
+			return err
+		}
+	}
+	return nil
+}
+
+// markNode sets the label, annotation and optional taint on node. Returns true if
+// the node was changed.
+func markNode(node *v1.Node, key string, ip string, effect v1.TaintEffect) bool {
+	changed := false
+	if node.Labels[key] != "true" {
+		if node.Labels == nil {
+			node.Labels = map[string]string{}
+		}
+		node.Labels[key] = "true"
+		changed = true
+	}
+	if node.Annotations[key] != ip {
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[key] = ip
+		changed = true
+	}
+
+	// Replace any taint with a different effect
+	taints := make([]v1.Taint, 0, len(node.Spec.Taints)+1)
+	tainted := false
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != key {
+			taints = append(taints, taint)
+		} else if taint.Effect == effect {
+			taints = append(taints, taint)
+			tainted = true
+		} else {
+			changed = true
+		}
+	}
+	if effect != "" && !tainted {
+		taints = append(taints, v1.Taint{Key: key, Value: "true", Effect: effect})
+		changed = true
+	}
+	node.Spec.Taints = taints
+	return changed
+}
+
+// unmarkNode removes the label, annotation and taint from node. Returns true if the
+// node was changed.
+func unmarkNode(node *v1.Node, key string) bool {
+	changed := false
+	if _, ok := node.Labels[key]; ok {
+		delete(node.Labels, key)
+		changed = true
+	}
+	if _, ok := node.Annotations[key]; ok {
+		delete(node.Annotations, key)
+		changed = true
+	}
+	taints := make([]v1.Taint, 0, len(node.Spec.Taints))
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == key {
+			changed = true
+		} else {
+			taints = append(taints, taint)
+		}
+	}
+	node.Spec.Taints = taints
+	return changed
+}
+
+// ignoreOwnNodeMarks drops Node updates which only change the labels, annotations and
+// taints under NodeLabelPrefix, so marking a node doesn't reconcile every binding again
+var ignoreOwnNodeMarks = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNode, ok := e.ObjectOld.(*v1.Node)
+		if !ok {
+			return true
+		}
+		newNode, ok := e.ObjectNew.(*v1.Node)
+		if !ok {
+			return true
+		}
+		return !equality.Semantic.DeepEqual(withoutOwnMarks(oldNode), withoutOwnMarks(newNode))
+	},
+}
+
+// withoutOwnMarks returns a copy of node without the marks under NodeLabelPrefix, or
+// the fields every update changes
+func withoutOwnMarks(node *v1.Node) *v1.Node {
+	node = node.DeepCopy()
+	node.ResourceVersion = ""
+	node.ManagedFields = nil
+	for key := range node.Labels {
+		if strings.HasPrefix(key, digitaloceanv1beta1.NodeLabelPrefix) {
+			delete(node.Labels, key)
+		}
+	}
+	for key := range node.Annotations {
+		if strings.HasPrefix(key, digitaloceanv1beta1.NodeLabelPrefix) {
+			delete(node.Annotations, key)
+		}
+	}
+	taints := make([]v1.Taint, 0, len(node.Spec.Taints))
+	for _, taint := range node.Spec.Taints {
+		if !strings.HasPrefix(taint.Key, digitaloceanv1beta1.NodeLabelPrefix) {
+			taints = append(taints, taint)
+		}
+	}
+	node.Spec.Taints = taints
+	return node
+}
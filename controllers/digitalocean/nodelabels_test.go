@@ -0,0 +1,110 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNodeLabelKey(t *testing.T) {
+	long := strings.Repeat("a", 60)
+	tests := []struct {
+		name    string
+		binding types.NamespacedName
+		want    string
+	}{
+		{
+			name:    "namespaced",
+			binding: types.NamespacedName{Namespace: "default", Name: "main"},
+			want:    "floating-ip.digitalocean.smirlwebs.com/default.main",
+		},
+		{
+			name:    "same name in another namespace",
+			binding: types.NamespacedName{Namespace: "other", Name: "main"},
+			want:    "floating-ip.digitalocean.smirlwebs.com/other.main",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NodeLabelKey(tt.binding)
+			if err != nil {
+				t.Fatalf("NodeLabelKey() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("NodeLabelKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("long names are hashed", func(t *testing.T) {
+		first, err := NodeLabelKey(types.NamespacedName{Namespace: "default", Name: long + "-1"})
+		if err != nil {
+			t.Fatalf("NodeLabelKey() error = %v", err)
+		}
+		second, err := NodeLabelKey(types.NamespacedName{Namespace: "default", Name: long + "-2"})
+		if err != nil {
+			t.Fatalf("NodeLabelKey() error = %v", err)
+		}
+		if first == second {
+			t.Errorf("NodeLabelKey() = %q for both bindings", first)
+		}
+		if errs := validation.IsQualifiedName(first); len(errs) > 0 {
+			t.Errorf("NodeLabelKey() = %q is invalid: %v", first, errs)
+		}
+	})
+}
+
+func TestIgnoreOwnNodeMarks(t *testing.T) {
+	key, err := NodeLabelKey(types.NamespacedName{Namespace: "default", Name: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "node",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"role": "web"},
+		},
+	}
+
+	marked := node.DeepCopy()
+	marked.ResourceVersion = "2"
+	markNode(marked, key, "203.0.113.1", v1.TaintEffectNoSchedule)
+	if ignoreOwnNodeMarks.Update(event.UpdateEvent{ObjectOld: node, ObjectNew: marked}) {
+		t.Error("Update() = true for an update which only marks the node")
+	}
+
+	relabelled := marked.DeepCopy()
+	relabelled.ResourceVersion = "3"
+	relabelled.Labels["role"] = "db"
+	if !ignoreOwnNodeMarks.Update(event.UpdateEvent{ObjectOld: marked, ObjectNew: relabelled}) {
+		t.Error("Update() = false for an update which changes another label")
+	}
+
+	cordoned := marked.DeepCopy()
+	cordoned.Spec.Unschedulable = true
+	if !ignoreOwnNodeMarks.Update(event.UpdateEvent{ObjectOld: marked, ObjectNew: cordoned}) {
+		t.Error("Update() = false for an update which cordons the node")
+	}
+}
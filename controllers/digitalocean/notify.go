@@ -0,0 +1,182 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/notify"
+)
+
+// FailureNotifyThreshold is the number of consecutive failed reconciles of a binding
+// after which a Failed notification is sent
+const FailureNotifyThreshold = 3
+
+// Notification describes an event to send to the binding's notifiers
+type Notification struct {
+	Event      digitaloceanv1beta1.NotificationEvent
+	OldDroplet *Droplet
+	NewDroplet *Droplet
+	Reason     string
+	Message    string
+}
+
+//+kubebuilder:rbac:groups=digitalocean.smirlwebs.com,resources=floatingipnotifiers,verbs=get;list;watch
+
+// Notify queues the notification for every FloatingIPNotifier in the binding's
+// namespace which selects the binding and the event
+func (r *FloatingIPBindingReconciler) Notify(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	notification Notification,
+) {
+	if r.Notifier == nil {
+		return
+	}
+
+	var notifiers digitaloceanv1beta1.FloatingIPNotifierList
+	if err := r.List(ctx, &notifiers, client.InNamespace(binding.GetNamespace())); err != nil {
+		log.Error(err, "Failed to list floating IP notifiers")
+		return
+	}
+
+	payload := notify.Payload{
+		Event:      string(notification.Event),
+		Binding:    notify.BindingRef{Namespace: binding.GetNamespace(), Name: binding.GetName()},
+		FloatingIP: binding.Spec.FloatingIP,
+		OldDroplet: notifyDroplet(notification.OldDroplet),
+		NewDroplet: notifyDroplet(notification.NewDroplet),
+		Reason:     notification.Reason,
+		Message:    notification.Message,
+		Timestamp:  time.Now().UTC(),
+	}
+	for i := range notifiers.Items {
+		notifier := &notifiers.Items[i]
+		log := log.WithValues("floatingipnotifier", notifier.GetName())
+		if !notifierSelects(log, notifier, binding, notification.Event) {
+			continue
+		}
+		delivery := notify.Delivery{
+			URL:        notifier.Spec.URL,
+			MaxRetries: notifier.Spec.MaxRetries,
+			Payload:    payload,
+		}
+		if ref := notifier.Spec.SecretRef; ref != nil {
+			secret := &v1.Secret{}
+			err := r.apiReader().Get(ctx, types.NamespacedName{Namespace: notifier.GetNamespace(), Name: ref.Name}, secret)
+			if err != nil {
+				log.Error(err, "Failed to get notifier secret. Skipping.")
+				continue
+			}
+			delivery.Secret = secret.Data[ref.Key]
+			if len(delivery.Secret) == 0 {
+				log.Info("Notifier secret key is empty. Skipping.", "secret", ref.Name, "key", ref.Key)
+				continue
+			}
+		}
+		r.Notifier.Enqueue(delivery)
+	}
+}
+
+// notifierSelects returns true if the notifier wants the event for the binding
+func notifierSelects(
+	log logr.Logger,
+	notifier *digitaloceanv1beta1.FloatingIPNotifier,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	event digitaloceanv1beta1.NotificationEvent,
+) bool {
+	if len(notifier.Spec.Events) > 0 {
+		found := false
+		for _, e := range notifier.Spec.Events {
+			found = found || e == event
+		}
+		if !found {
+			return false
+		}
+	}
+	if notifier.Spec.BindingSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(notifier.Spec.BindingSelector)
+	if err != nil {
+		log.Error(err, "Could not parse BindingSelector")
+		return false
+	}
+	return selector.Matches(labels.Set(binding.GetLabels()))
+}
+
+// TrackFailure counts consecutive failed reconciles of a binding and sends a Failed
+// notification when the count reaches FailureNotifyThreshold. It returns the count.
+func (r *FloatingIPBindingReconciler) TrackFailure(
+	ctx context.Context,
+	log logr.Logger,
+	name types.NamespacedName,
+	reconcileErr error,
+) int {
+	r.failuresMu.Lock()
+	if r.failures == nil {
+		r.failures = map[types.NamespacedName]int{}
+	}
+	if reconcileErr == nil {
+		delete(r.failures, name)
+		r.failuresMu.Unlock()
+		return 0
+	}
+	r.failures[name]++
+	count := r.failures[name]
+	r.failuresMu.Unlock()
+
+	if count != FailureNotifyThreshold {
+		return count
+	}
+	binding := &digitaloceanv1beta1.FloatingIPBinding{}
+	if err := r.Get(ctx, name, binding); err != nil {
+		return count
+	}
+	r.Notify(ctx, log, binding, Notification{
+		Event:      digitaloceanv1beta1.NotifyFailed,
+		OldDroplet: statusDroplet(binding),
+		Reason:     "ReconcileFailed",
+		Message:    reconcileErr.Error(),
+	})
+	return count
+}
+
+// statusDroplet returns the droplet in the binding's status, or nil if unassigned
+func statusDroplet(binding *digitaloceanv1beta1.FloatingIPBinding) *Droplet {
+	if binding.Status.AssignedDropletID == 0 {
+		return nil
+	}
+	return &Droplet{ID: binding.Status.AssignedDropletID, Name: binding.Status.AssignedDropletName}
+}
+
+func notifyDroplet(droplet *Droplet) *notify.Droplet {
+	if droplet == nil {
+		return nil
+	}
+	return &notify.Droplet{ID: droplet.ID, Name: droplet.Name}
+}
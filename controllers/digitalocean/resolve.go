@@ -0,0 +1,189 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// ProviderIDPrefix is the prefix of the providerID of nodes running on droplets
+const ProviderIDPrefix = "digitalocean://"
+
+// dropletIDFromProviderID returns the droplet ID from a node's providerID
+func dropletIDFromProviderID(providerID string) (int, error) {
+	if !strings.HasPrefix(providerID, ProviderIDPrefix) {
+		return 0, fmt.Errorf("providerID %q is not a DigitalOcean providerID", providerID)
+	}
+	dropletID, err := strconv.Atoi(strings.TrimPrefix(providerID, ProviderIDPrefix))
+	if err != nil || dropletID <= 0 {
+		return 0, fmt.Errorf("providerID %q does not contain a droplet ID", providerID)
+	}
+	return dropletID, nil
+}
+
+// DropletCacheTTL is how long the account's droplets are cached for resolving nodes
+// without a providerID when DropletCacheTTL isn't set on the reconciler
+const DropletCacheTTL = time.Minute
+
+// ambiguousDroplet marks a name or IP address shared by more than one droplet
+const ambiguousDroplet = -1
+
+type cachedDroplets struct {
+	listed time.Time
+	// byKey maps droplet names and IP addresses to droplet IDs
+	byKey map[string]int
+}
+
+// DropletCache caches the droplets listed from each provider so nodes without a
+// providerID don't list every droplet in the account on every reconcile
+type DropletCache struct {
+	mu       sync.Mutex
+	droplets map[provider.FloatingIPProvider]cachedDroplets
+}
+
+// Lookup returns the droplets by name and IP address, listing them again once they are
+// older than ttl
+func (c *DropletCache) Lookup(
+	ctx context.Context,
+	doProvider provider.FloatingIPProvider,
+	ttl time.Duration,
+) (map[string]int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.droplets[doProvider]; ok && time.Since(cached.listed) < ttl {
+		return cached.byKey, nil
+	}
+	droplets, err := doProvider.ListDroplets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := map[string]int{}
+	add := func(key string, dropletID int) {
+		if key == "" {
+			return
+		}
+		if existing, ok := byKey[key]; ok && existing != dropletID {
+			byKey[key] = ambiguousDroplet
+			return
+		}
+		byKey[key] = dropletID
+	}
+	for i := range droplets {
+		droplet := &droplets[i]
+		add(droplet.Name, droplet.ID)
+		if ip, err := droplet.PublicIPv4(); err == nil {
+			add(ip, droplet.ID)
+		}
+		if ip, err := droplet.PrivateIPv4(); err == nil {
+			add(ip, droplet.ID)
+		}
+	}
+	if c.droplets == nil {
+		c.droplets = map[provider.FloatingIPProvider]cachedDroplets{}
+	}
+	c.droplets[doProvider] = cachedDroplets{listed: time.Now(), byKey: byKey}
+	return byKey, nil
+}
+
+// matchDroplet returns the droplet matching all of the keys which belong to a droplet.
+// It returns false when nothing matches, a key is shared by several droplets or keys
+// match different droplets.
+func matchDroplet(byKey map[string]int, keys []string) (int, bool) {
+	match := 0
+	for _, key := range keys {
+		dropletID, ok := byKey[key]
+		if !ok {
+			continue
+		}
+		if dropletID == ambiguousDroplet || (match != 0 && match != dropletID) {
+			return 0, false
+		}
+		match = dropletID
+	}
+	return match, match != 0
+}
+
+// ResolveDropletIDs returns the droplet ID of each node by node name. Nodes without a
+// DigitalOcean providerID, as in self-managed clusters, are matched against droplets
+// by name, hostname or IP address. Nodes which can't be resolved, or which match more
+// than one droplet, are left out.
+func (r *FloatingIPBindingReconciler) ResolveDropletIDs(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	nodes []v1.Node,
+) map[string]int {
+	dropletIDs := map[string]int{}
+	var unresolved []v1.Node
+	for _, node := range nodes {
+		dropletID, err := dropletIDFromProviderID(node.Spec.ProviderID)
+		if err != nil {
+			unresolved = append(unresolved, node)
+			continue
+		}
+		dropletIDs[node.GetName()] = dropletID
+	}
+	if len(unresolved) == 0 {
+		return dropletIDs
+	}
+
+	// Fall back to matching against the droplets in the account
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return dropletIDs
+	}
+	ttl := r.DropletCacheTTL
+	if ttl == 0 {
+		ttl = DropletCacheTTL
+	}
+	dropletsByKey, err := r.DropletCache.Lookup(ctx, doProvider, ttl)
+	if err != nil {
+		log.Error(err, "Failed to list droplets. Skipping nodes without a providerID.")
+		return dropletIDs
+	}
+
+	for _, node := range unresolved {
+		keys := []string{node.GetName()}
+		for _, address := range node.Status.Addresses {
+			switch address.Type {
+			case v1.NodeHostName, v1.NodeInternalIP, v1.NodeExternalIP:
+				keys = append(keys, address.Address)
+			}
+		}
+		dropletID, ok := matchDroplet(dropletsByKey, keys)
+		if !ok {
+			log.Info("Could not resolve a single droplet for node. Skipping.", "node", node.GetName(), "providerID", node.Spec.ProviderID)
+			continue
+		}
+		dropletIDs[node.GetName()] = dropletID
+	}
+	return dropletIDs
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+func dropletWithIPs(id int, name string, public string, private string) godo.Droplet {
+	return godo.Droplet{
+		ID:   id,
+		Name: name,
+		Networks: &godo.Networks{
+			V4: []godo.NetworkV4{
+				{IPAddress: public, Type: "public"},
+				{IPAddress: private, Type: "private"},
+			},
+		},
+	}
+}
+
+func nodeWithAddress(name string, providerID string, internalIP string) v1.Node {
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+	}
+	if internalIP != "" {
+		node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: internalIP}}
+	}
+	return node
+}
+
+func TestResolveDropletIDs(t *testing.T) {
+	fake := provider.NewFake()
+	fake.AddDroplet(dropletWithIPs(1, "unique", "203.0.113.1", "10.0.0.1"))
+	fake.AddDroplet(dropletWithIPs(2, "shared-name", "203.0.113.2", "10.0.0.2"))
+	fake.AddDroplet(dropletWithIPs(3, "shared-name", "203.0.113.3", "10.0.0.3"))
+	fake.AddDroplet(dropletWithIPs(4, "by-ip", "203.0.113.4", "10.0.0.4"))
+	r := &FloatingIPBindingReconciler{Provider: NewProviderHolder(fake)}
+
+	nodes := []v1.Node{
+		nodeWithAddress("from-provider-id", "digitalocean://99", ""),
+		nodeWithAddress("unique", "", ""),
+		nodeWithAddress("shared-name", "", ""),
+		nodeWithAddress("renamed", "", "10.0.0.4"),
+		nodeWithAddress("by-ip", "", "10.0.0.1"),
+		nodeWithAddress("unknown", "", "10.0.0.99"),
+	}
+	got := r.ResolveDropletIDs(context.Background(), ctrl.Log, &digitaloceanv1beta1.FloatingIPBinding{}, nodes)
+	want := map[string]int{
+		"from-provider-id": 99,
+		"unique":           1,
+		"renamed":          4,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResolveDropletIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestDropletCacheLookup(t *testing.T) {
+	fake := provider.NewFake()
+	fake.AddDroplet(dropletWithIPs(1, "node", "203.0.113.1", "10.0.0.1"))
+	cache := &DropletCache{}
+	ctx := context.Background()
+
+	if _, err := cache.Lookup(ctx, fake, time.Hour); err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	fake.AddDroplet(dropletWithIPs(2, "new-node", "203.0.113.2", "10.0.0.2"))
+	byKey, err := cache.Lookup(ctx, fake, time.Hour)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if calls := fake.Calls("ListDroplets"); calls != 1 {
+		t.Errorf("ListDroplets called %d times, want 1", calls)
+	}
+	if _, ok := byKey["new-node"]; ok {
+		t.Error("Lookup() listed droplets again before the ttl expired")
+	}
+
+	byKey, err = cache.Lookup(ctx, fake, 0)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if byKey["new-node"] != 2 {
+		t.Errorf("Lookup() after expiry = %v, want new-node listed", byKey)
+	}
+}
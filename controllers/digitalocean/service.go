@@ -0,0 +1,253 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=services/status,verbs=get;update;patch
+
+// SyncService publishes the floating IP in the status of the binding's Service, and
+// removes it from the previously published Service when spec.serviceRef changes
+func (r *FloatingIPBindingReconciler) SyncService(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	ref := binding.Spec.ServiceRef
+	current := binding.Status.Service
+	if current != nil && (ref == nil || current.Name != ref.Name) {
+		if err := r.UnpublishService(ctx, log, binding); err != nil {
+			return err
+		}
+	}
+	if ref == nil {
+		meta.RemoveStatusCondition(&binding.Status.Conditions, digitaloceanv1beta1.ConditionServiceReady)
+		return nil
+	}
+
+	err := r.publishService(ctx, log, binding)
+	var notOwned *ServiceNotOwnedError
+	if errors.As(err, &notOwned) {
+		// Leave the Service to its load balancer controller until it changes
+		condition := meta.FindStatusCondition(binding.Status.Conditions, digitaloceanv1beta1.ConditionServiceReady)
+		if condition == nil || condition.Reason != "ServiceNotOwned" {
+			log.Info("Not publishing floatingIP in a Service owned by another load balancer controller",
+				"service", ref.Name)
+			r.Recorder.Event(binding, v1.EventTypeWarning, "ServiceNotOwned", err.Error())
+		}
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionServiceReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: binding.Generation,
+			Reason:             "ServiceNotOwned",
+			Message:            err.Error(),
+		})
+		return nil
+	}
+	if err != nil {
+		r.Recorder.Event(binding, v1.EventTypeWarning, "ServiceSyncFailed", err.Error())
+		meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+			Type:               digitaloceanv1beta1.ConditionServiceReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: binding.Generation,
+			Reason:             "SyncFailed",
+			Message:            err.Error(),
+		})
+		return err
+	}
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               digitaloceanv1beta1.ConditionServiceReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: binding.Generation,
+		Reason:             "Synced",
+		Message:            fmt.Sprintf("Service %s lists the floating IP", ref.Name),
+	})
+	return nil
+}
+
+func (r *FloatingIPBindingReconciler) publishService(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	name := binding.Spec.ServiceRef.Name
+	ip := binding.Spec.FloatingIP
+	log = log.WithValues("service", name)
+	service := &v1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: binding.Namespace, Name: name}, service); err != nil {
+		log.Error(err, "Failed to get Service")
+		return err
+	}
+	if owner := loadBalancerOwner(service); owner != "" {
+		// Take back a floating IP published before another controller took the Service over
+		if binding.Status.Service != nil && binding.Status.Service.Name == name {
+			if err := r.UnpublishService(ctx, log, binding); err != nil {
+				return err
+			}
+		}
+		return &ServiceNotOwnedError{Service: name, Owner: owner}
+	}
+
+	// Replace the floating IP previously published by the binding, keeping any other entries
+	previous := ""
+	if binding.Status.Service != nil && binding.Status.Service.Name == name {
+		previous = binding.Status.Service.IP
+	}
+	ingress := []v1.LoadBalancerIngress{{IP: ip}}
+	for _, entry := range service.Status.LoadBalancer.Ingress {
+		if entry.IP != ip && (previous == "" || entry.IP != previous) {
+			ingress = append(ingress, entry)
+		}
+	}
+	if !hasIngressIP(service, ip) || len(ingress) != len(service.Status.LoadBalancer.Ingress) {
+		service.Status.LoadBalancer.Ingress = ingress
+		if err := r.Status().Update(ctx, service); err != nil {
+			log.Error(err, "Failed to update Service status")
+			return err
+		}
+		log.Info("Published floatingIP in Service status")
+		r.Recorder.Eventf(binding, v1.EventTypeNormal, "ServicePublished",
+			"Published floating IP %s in the status of Service %s", ip, name)
+	}
+
+	binding.Status.Service = &digitaloceanv1beta1.PublishedService{Name: name, IP: ip}
+	return nil
+}
+
+// UnpublishService removes the floating IP from the status of the Service it was
+// published in
+func (r *FloatingIPBindingReconciler) UnpublishService(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) error {
+	current := binding.Status.Service
+	if current == nil {
+		return nil
+	}
+	log = log.WithValues("service", current.Name)
+	service := &v1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: binding.Namespace, Name: current.Name}, service)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get Service")
+		return err
+	}
+	if err == nil && hasIngressIP(service, current.IP) {
+		var ingress []v1.LoadBalancerIngress
+		for _, entry := range service.Status.LoadBalancer.Ingress {
+			if entry.IP != current.IP {
+				ingress = append(ingress, entry)
+			}
+		}
+		service.Status.LoadBalancer.Ingress = ingress
+		if err := r.Status().Update(ctx, service); err != nil {
+			log.Error(err, "Failed to update Service status")
+			return err
+		}
+		log.Info("Removed floatingIP from Service status")
+		r.Recorder.Eventf(binding, v1.EventTypeNormal, "ServiceUnpublished",
+			"Removed floating IP %s from the status of Service %s", current.IP, current.Name)
+	}
+	binding.Status.Service = nil
+	return nil
+}
+
+// ServiceNotOwnedError is returned when the status of a Service belongs to another load
+// balancer controller
+type ServiceNotOwnedError struct {
+	Service string
+	Owner   string
+}
+
+func (e *ServiceNotOwnedError) Error() string {
+	return fmt.Sprintf("Service %s is a LoadBalancer managed by %s, set its loadBalancerClass to %s to publish the floating IP",
+		e.Service, e.Owner, digitaloceanv1beta1.LoadBalancerClass)
+}
+
+// loadBalancerOwner describes the load balancer controller which owns the Service's status,
+// or returns an empty string if the floating IP may be published in it. Only LoadBalancer
+// Services have a load balancer controller, and those with LoadBalancerClass are left to
+// this controller.
+func loadBalancerOwner(service *v1.Service) string {
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return ""
+	}
+	class := service.Spec.LoadBalancerClass
+	if class == nil {
+		return "the cluster's default load balancer controller"
+	}
+	if *class != digitaloceanv1beta1.LoadBalancerClass {
+		return "the controller of loadBalancerClass " + *class
+	}
+	return ""
+}
+
+// hasIngressIP returns true when the Service's load balancer status lists ip
+func hasIngressIP(service *v1.Service, ip string) bool {
+	for _, entry := range service.Status.LoadBalancer.Ingress {
+		if entry.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *FloatingIPBindingReconciler) serviceToRequests(service client.Object) []reconcile.Request {
+	// List bindings in the same namespace which publish to the service
+	var bindings digitaloceanv1beta1.FloatingIPBindingList
+	err := r.List(context.Background(), &bindings, client.InNamespace(service.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list floating IP bindings")
+		return []reconcile.Request{}
+	}
+
+	var reconcileRequests []reconcile.Request
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if !r.Claims(binding) {
+			continue
+		}
+		referenced := binding.Spec.ServiceRef != nil && binding.Spec.ServiceRef.Name == service.GetName()
+		published := binding.Status.Service != nil && binding.Status.Service.Name == service.GetName()
+		if !referenced && !published {
+			continue
+		}
+		reconcileRequests = append(reconcileRequests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      binding.GetName(),
+				Namespace: binding.GetNamespace(),
+			},
+		})
+	}
+	return reconcileRequests
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+func TestLoadBalancerOwner(t *testing.T) {
+	ours := digitaloceanv1beta1.LoadBalancerClass
+	other := "example.com/other-lb"
+	tests := []struct {
+		name  string
+		spec  v1.ServiceSpec
+		owned bool
+	}{
+		{"cluster ip", v1.ServiceSpec{Type: v1.ServiceTypeClusterIP}, false},
+		{"node port", v1.ServiceSpec{Type: v1.ServiceTypeNodePort}, false},
+		{"default load balancer", v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}, true},
+		{"other load balancer class", v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, LoadBalancerClass: &other}, true},
+		{"our load balancer class", v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, LoadBalancerClass: &ours}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := loadBalancerOwner(&v1.Service{Spec: tt.spec})
+			if (owner != "") != tt.owned {
+				t.Errorf("loadBalancerOwner() = %q, want owned by another controller %v", owner, tt.owned)
+			}
+		})
+	}
+}
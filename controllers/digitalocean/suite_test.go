@@ -17,12 +17,12 @@ limitations under the License.
 package digitalocean
 
 import (
-	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/digitalocean/godo"
-	"github.com/jarcoal/httpmock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"golang.org/x/net/context"
@@ -35,6 +35,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/fakedo"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/notify"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -46,6 +49,27 @@ var k8sClient client.Client
 var testEnv *envtest.Environment
 var ctx context.Context
 var cancel context.CancelFunc
+var fakeProvider *provider.Fake
+var fakeServer *httptest.Server
+var providerTokens = &tokenLog{}
+
+// tokenLog records the tokens providers are built from for bindings' credentials
+type tokenLog struct {
+	mu     sync.Mutex
+	tokens []string
+}
+
+func (l *tokenLog) add(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = append(l.tokens, token)
+}
+
+func (l *tokenLog) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.tokens...)
+}
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -72,20 +96,58 @@ var _ = BeforeSuite(func() {
 
 	//+kubebuilder:scaffold:scheme
 
-	httpClient := http.Client{Transport: &http.Transport{}}
-	httpmock.ActivateNonDefault(&httpClient)
-	doClient := godo.NewClient(&httpClient)
+	// Run the fake DigitalOcean API and talk to it through godo as the manager would with --do-api-url
+	fakeProvider = provider.NewFake()
+	fakeServer = httptest.NewServer(fakedo.NewServer(fakeProvider))
+	newProvider, err := provider.NewGodoProviderFunc(provider.GodoOptions{APIURL: fakeServer.URL})
+	Expect(err).NotTo(HaveOccurred())
 
 	k8sManager, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme: scheme.Scheme,
 	})
 	Expect(err).ToNot(HaveOccurred())
 
+	notifier := notify.NewDispatcher(ctrl.Log.WithName("notify"))
+	notifier.BaseDelay = time.Millisecond * 10
+	Expect(k8sManager.Add(notifier)).To(Succeed())
+
 	err = (&FloatingIPBindingReconciler{
-		Client:             k8sManager.GetClient(),
-		Scheme:             k8sManager.GetScheme(),
-		Log:                ctrl.Log.WithName("controllers").WithName("FloatingIPBinding"),
-		DigitaloceanClient: doClient,
+		Client:   k8sManager.GetClient(),
+		Scheme:   k8sManager.GetScheme(),
+		Log:      ctrl.Log.WithName("controllers").WithName("FloatingIPBinding"),
+		Provider: NewProviderHolder(newProvider("fake")),
+		Recorder: k8sManager.GetEventRecorderFor("floatingipbinding-controller"),
+		ProviderCache: ProviderCache{
+			NewProvider: func(token string) provider.FloatingIPProvider {
+				providerTokens.add(token)
+				return newProvider(token)
+			},
+		},
+		APIReader:       k8sManager.GetAPIReader(),
+		Notifier:        notifier,
+		RetryBaseDelay:  time.Millisecond * 10,
+		DropletCacheTTL: time.Millisecond * 100,
+		// The lease isn't renewed, so bindings of other instances are reported as unclaimed
+		Leases: &ControllerLease{
+			Client:         k8sManager.GetClient(),
+			Reader:         k8sManager.GetAPIReader(),
+			Namespace:      "default",
+			ControllerName: digitaloceanv1beta1.DefaultControllerName,
+		},
+	}).SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&FloatingIPClaimReconciler{
+		Client:   k8sManager.GetClient(),
+		Scheme:   k8sManager.GetScheme(),
+		Log:      ctrl.Log.WithName("controllers").WithName("FloatingIPClaim"),
+		Provider: NewProviderHolder(newProvider("fake")),
+		Recorder: k8sManager.GetEventRecorderFor("floatingipclaim-controller"),
+		ProviderCache: ProviderCache{
+			NewProvider: newProvider,
+		},
+		APIReader:       k8sManager.GetAPIReader(),
+		RequeueInterval: time.Millisecond * 100,
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -102,13 +164,13 @@ var _ = BeforeSuite(func() {
 })
 
 var _ = BeforeEach(func() {
-	httpmock.Reset() // remove any mocks
+	fakeProvider.Reset() // remove any state and faults
 })
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
 	cancel()
+	fakeServer.Close()
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
-	httpmock.DeactivateAndReset()
 })
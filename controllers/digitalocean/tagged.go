@@ -0,0 +1,105 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+// DropletTagPollInterval is how often bindings targeting a DropletTag are reconciled
+// when TagPollInterval isn't set, as droplets which aren't nodes can't be watched
+const DropletTagPollInterval = time.Minute
+
+// TargetsDropletTag returns true when the binding selects droplets by tag instead of nodes
+func TargetsDropletTag(binding *digitaloceanv1beta1.FloatingIPBinding) bool {
+	return binding.Spec.Target == digitaloceanv1beta1.DropletTag
+}
+
+// GetTaggedDroplet chooses a droplet from those with the binding's DropletTag
+func (r *FloatingIPBindingReconciler) GetTaggedDroplet(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+) (*Droplet, error) {
+	if binding.Spec.DropletTag == "" {
+		err := Permanent(errors.New("dropletTag must be set when target is DropletTag"))
+		log.Error(err, "Invalid FloatingIPBinding")
+		return nil, err
+	}
+
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to get DigitalOcean provider")
+		return nil, err
+	}
+	droplets, err := doProvider.ListDropletsByTag(ctx, binding.Spec.DropletTag)
+	if err != nil {
+		log.Error(err, "Could not list droplets", "tag", binding.Spec.DropletTag)
+		return nil, err
+	}
+
+	eligible := make([]candidate, 0, len(droplets))
+	for _, droplet := range droplets {
+		created, err := time.Parse(time.RFC3339, droplet.Created)
+		if err != nil {
+			log.Info("Could not parse droplet creation time", "dropletID", droplet.ID, "created", droplet.Created)
+		}
+		eligible = append(eligible, candidate{
+			Droplet: Droplet{ID: droplet.ID, Name: droplet.Name},
+			Created: metav1.NewTime(created),
+		})
+	}
+
+	// Sort droplets by Age
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].Created.Before(&eligible[j].Created)
+	})
+
+	return r.SelectCandidate(log, binding, eligible, nil)
+}
+
+// HasDropletTag checks whether the droplet with the given ID has the binding's DropletTag
+func (r *FloatingIPBindingReconciler) HasDropletTag(
+	ctx context.Context,
+	log logr.Logger,
+	binding *digitaloceanv1beta1.FloatingIPBinding,
+	dropletID int,
+) (bool, error) {
+	doProvider, err := r.ProviderFor(ctx, binding)
+	if err != nil {
+		return false, err
+	}
+	droplets, err := doProvider.ListDropletsByTag(ctx, binding.Spec.DropletTag)
+	if err != nil {
+		log.Error(err, "Could not list droplets", "tag", binding.Spec.DropletTag)
+		return false, err
+	}
+	for _, droplet := range droplets {
+		if droplet.ID == dropletID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// ValidationInterval is how long a successful token validation is trusted by the readyz check
+const ValidationInterval = time.Minute
+
+// ProviderHolder holds the global provider so that it can be swapped atomically
+// when the token changes
+type ProviderHolder struct {
+	provider atomic.Value
+
+	mu sync.Mutex
+	// generation counts the providers stored, so validations of a replaced provider are dropped
+	generation    uint64
+	lastValidated time.Time
+	lastErr       error
+	storedAt      time.Time
+}
+
+// NewProviderHolder returns a ProviderHolder holding p
+func NewProviderHolder(p provider.FloatingIPProvider) *ProviderHolder {
+	h := &ProviderHolder{}
+	h.Store(p)
+	return h
+}
+
+// providerBox lets providers of different concrete types share an atomic.Value
+type providerBox struct {
+	provider.FloatingIPProvider
+}
+
+// Load returns the current provider
+func (h *ProviderHolder) Load() provider.FloatingIPProvider {
+	return h.provider.Load().(providerBox).FloatingIPProvider
+}
+
+// Store swaps the current provider and forgets any previous validation
+func (h *ProviderHolder) Store(p provider.FloatingIPProvider) {
+	h.mu.Lock()
+	h.provider.Store(providerBox{p})
+	h.generation++
+	h.lastValidated = time.Time{}
+	h.storedAt = time.Now()
+	h.mu.Unlock()
+}
+
+// StoredAt returns when the current provider was stored
+func (h *ProviderHolder) StoredAt() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.storedAt
+}
+
+// Validate checks the current token by calling the Account endpoint. The lock isn't
+// held during the call, and the result is only cached if the provider wasn't replaced.
+func (h *ProviderHolder) Validate(ctx context.Context) error {
+	h.mu.Lock()
+	if time.Since(h.lastValidated) < ValidationInterval {
+		err := h.lastErr
+		h.mu.Unlock()
+		return err
+	}
+	generation := h.generation
+	current := h.Load()
+	h.mu.Unlock()
+
+	err := current.Validate(ctx)
+	if err != nil {
+		err = fmt.Errorf("could not validate DigitalOcean token: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.generation == generation {
+		h.lastValidated = time.Now()
+		h.lastErr = err
+	}
+	return err
+}
+
+// Checker is a readyz check which fails when the token can't be validated
+func (h *ProviderHolder) Checker(req *http.Request) error {
+	return h.Validate(req.Context())
+}
+
+// ReadTokenFile reads a DigitalOcean API token from a file
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// TokenFileWatcher reloads the token from a file whenever it changes and swaps
+// the provider in Holder. It implements manager.Runnable and runs on every replica,
+// so the readyz check of a standby validates the current token.
+type TokenFileWatcher struct {
+	Path   string
+	Holder *ProviderHolder
+	Log    logr.Logger
+	// Token is the token of the provider in Holder, read when the controller started.
+	// The file is compared against it when the watcher starts, in case it has changed since.
+	Token string
+	// NewProvider builds a provider from a token. Defaults to provider.NewGodoProvider
+	NewProvider func(token string) provider.FloatingIPProvider
+
+	token string
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The token is reloaded
+// whether or not this replica is the leader.
+func (w *TokenFileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the token file until the context is cancelled
+func (w *TokenFileWatcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Watch the directory as mounted secrets are updated by swapping a symlink
+	if err := watcher.Add(filepath.Dir(w.Path)); err != nil {
+		return err
+	}
+	// Pick up a token written between the controller reading the file and the watcher starting
+	w.token = w.Token
+	w.reload()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+				w.reload()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			w.Log.Error(err, "Error watching token file", "path", w.Path)
+		}
+	}
+}
+
+func (w *TokenFileWatcher) reload() {
+	token, err := ReadTokenFile(w.Path)
+	if err != nil {
+		w.Log.Error(err, "Could not read token file", "path", w.Path)
+		return
+	}
+	if token == w.token {
+		return
+	}
+
+	newProvider := w.NewProvider
+	if newProvider == nil {
+		newProvider = provider.NewGodoProvider
+	}
+	w.Holder.Store(newProvider(token))
+	w.token = token
+	w.Log.Info("Reloaded DigitalOcean token", "path", w.Path)
+}
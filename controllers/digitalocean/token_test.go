@@ -0,0 +1,255 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+func TestReadTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content *string
+		want    string
+		wantErr bool
+	}{
+		{name: "trims whitespace", content: strPtr("  abc123\n"), want: "abc123"},
+		{name: "empty", content: strPtr(" \n"), wantErr: true},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if tt.content != nil {
+				if err := os.WriteFile(path, []byte(*tt.content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := ReadTokenFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadTokenFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ReadTokenFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderHolderChecker(t *testing.T) {
+	fake := provider.NewFake()
+	holder := NewProviderHolder(fake)
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	fake.FailNext("Validate", &provider.StatusError{StatusCode: 401, Message: "Unable to authenticate you"})
+	if err := holder.Checker(req); err == nil {
+		t.Fatal("Checker() should fail with an invalid token")
+	}
+	// The failure is cached for ValidationInterval
+	if err := holder.Checker(req); err == nil {
+		t.Fatal("Checker() should return the cached failure")
+	}
+	if calls := fake.Calls("Validate"); calls != 1 {
+		t.Errorf("Validate called %d times, want 1", calls)
+	}
+
+	// Storing a provider forgets the cached result
+	holder.Store(fake)
+	if err := holder.Checker(req); err != nil {
+		t.Fatalf("Checker() error = %v after storing a provider", err)
+	}
+	if calls := fake.Calls("Validate"); calls != 2 {
+		t.Errorf("Validate called %d times, want 2", calls)
+	}
+}
+
+// blockingProvider blocks Validate until release is closed
+type blockingProvider struct {
+	*provider.Fake
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProvider) Validate(ctx context.Context) error {
+	close(p.started)
+	<-p.release
+	return errors.New("token revoked")
+}
+
+func TestProviderHolderDropsStaleValidation(t *testing.T) {
+	old := &blockingProvider{Fake: provider.NewFake(), started: make(chan struct{}), release: make(chan struct{})}
+	holder := NewProviderHolder(old)
+
+	done := make(chan error)
+	go func() { done <- holder.Validate(context.Background()) }()
+	<-old.started
+
+	// The lock isn't held while the old provider is validated
+	current := provider.NewFake()
+	stored := make(chan struct{})
+	go func() {
+		holder.Store(current)
+		close(stored)
+	}()
+	select {
+	case <-stored:
+	case <-time.After(time.Second):
+		t.Fatal("Store() blocked by a Validate in flight")
+	}
+
+	close(old.release)
+	if err := <-done; err == nil {
+		t.Fatal("Validate() of the old provider should fail")
+	}
+
+	// The old provider's failure isn't cached for the new provider
+	if err := holder.Validate(context.Background()); err != nil {
+		t.Fatalf("Validate() error = %v, want the new provider's result", err)
+	}
+	if calls := current.Calls("Validate"); calls != 1 {
+		t.Errorf("new provider Validate called %d times, want 1", calls)
+	}
+}
+
+func TestTokenFileWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var built []string
+	initial := provider.NewFake()
+	holder := NewProviderHolder(initial)
+	watcher := &TokenFileWatcher{
+		Path:   path,
+		Holder: holder,
+		Log:    ctrl.Log.WithName("token"),
+		Token:  "first",
+		NewProvider: func(token string) provider.FloatingIPProvider {
+			mu.Lock()
+			defer mu.Unlock()
+			built = append(built, token)
+			return provider.NewFake()
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error)
+	go func() { errs <- watcher.Start(ctx) }()
+
+	// Write new tokens until the watcher has started and picked one up
+	deadline := time.Now().Add(5 * time.Second)
+	written := map[string]bool{}
+	for i := 0; holder.Load() == initial; i++ {
+		if time.Now().After(deadline) {
+			t.Fatal("token was not reloaded")
+		}
+		token := fmt.Sprintf("token-%d", i)
+		written[token] = true
+		if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// An empty file keeps the current provider
+	reloaded := holder.Load()
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if holder.Load() != reloaded {
+		t.Error("provider was replaced after the token file was emptied")
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, token := range built {
+		if !written[token] {
+			t.Errorf("provider built from token %q which wasn't written", token)
+		}
+	}
+}
+
+func TestTokenFileWatcherReloadsOnStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("rotated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var built []string
+	initial := provider.NewFake()
+	holder := NewProviderHolder(initial)
+	watcher := &TokenFileWatcher{
+		Path:   path,
+		Holder: holder,
+		Log:    ctrl.Log.WithName("token"),
+		Token:  "stale",
+		NewProvider: func(token string) provider.FloatingIPProvider {
+			mu.Lock()
+			defer mu.Unlock()
+			built = append(built, token)
+			return provider.NewFake()
+		},
+	}
+	if watcher.NeedLeaderElection() {
+		t.Error("NeedLeaderElection() = true, want the token reloaded on every replica")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() { errs <- watcher.Start(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for holder.Load() == initial {
+		if time.Now().After(deadline) {
+			t.Fatal("token rotated before the watcher started was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(built) != 1 || built[0] != "rotated" {
+		t.Errorf("providers built from %v, want [rotated]", built)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"strings"
+
+	configv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/config/v1beta1"
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+	digitaloceancontrollers "github.com/smirl/digitalocean-floating-ip-controller/controllers/digitalocean"
+)
+
+// configFlags are the command-line flags which override fields of the --config file
+type configFlags struct {
+	apiURL          string
+	dryRun          bool
+	maxCandidates   int
+	controllerName  string
+	watchNamespaces string
+	leaseNamespace  string
+}
+
+// bind registers the flags on fs
+func (f *configFlags) bind(fs *flag.FlagSet) {
+	fs.StringVar(&f.apiURL, "do-api-url", "",
+		"The base URL of the DigitalOcean API, e.g. a fake API for local development. "+
+			"Omit this flag to use the DigitalOcean API.")
+	fs.BoolVar(&f.dryRun, "dry-run", false,
+		"Select droplets and record them in status.plannedDroplet without assigning floating IPs.")
+	fs.IntVar(&f.maxCandidates, "max-status-candidates", digitaloceancontrollers.DefaultMaxCandidates,
+		"The maximum number of nodes reported in each binding's status.candidates.")
+	fs.StringVar(&f.controllerName, "controller-name", digitaloceanv1beta1.DefaultControllerName,
+		"Only manage bindings with this spec.controllerName, so that several instances can run in one cluster.")
+	fs.StringVar(&f.watchNamespaces, "watch-namespaces", "",
+		"A comma separated list of namespaces to manage bindings in. Omit this flag to watch all namespaces.")
+	fs.StringVar(&f.leaseNamespace, "lease-namespace", "",
+		"The namespace of the Leases advertising running instances. Every instance in the cluster must use the same namespace. "+
+			"Defaults to the controller's namespace.")
+}
+
+// apply overrides the config with the flags which were set on the command line, so that
+// the defaults of unset flags don't replace values from the config file
+func (f *configFlags) apply(fs *flag.FlagSet, c *configv1beta1.FloatingIPControllerConfig) {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "do-api-url":
+			c.DigitalOcean.APIURL = f.apiURL
+		case "dry-run":
+			c.FloatingIPBinding.DryRun = f.dryRun
+		case "max-status-candidates":
+			c.FloatingIPBinding.MaxStatusCandidates = f.maxCandidates
+		case "controller-name":
+			c.ControllerName = f.controllerName
+		case "watch-namespaces":
+			c.WatchNamespaces = strings.Split(f.watchNamespaces, ",")
+		case "lease-namespace":
+			c.LeaseNamespace = f.leaseNamespace
+		}
+	})
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	configv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/config/v1beta1"
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+const testConfigFile = `apiVersion: config.smirlwebs.com/v1beta1
+kind: FloatingIPControllerConfig
+leaderElection:
+  leaderElect: false
+controllerName: example.com/from-file
+watchNamespaces: [file-a, file-b]
+leaseNamespace: file-leases
+digitalOcean:
+  apiURL: http://file.example.com
+floatingIPBinding:
+  dryRun: true
+  maxStatusCandidates: 5
+`
+
+func TestConfigFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfigFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		configFile bool
+		args       []string
+		want       func(c *configv1beta1.FloatingIPControllerConfig)
+	}{
+		{
+			name: "defaults without a file or flags",
+			want: func(c *configv1beta1.FloatingIPControllerConfig) {
+				c.ControllerName = digitaloceanv1beta1.DefaultControllerName
+				c.FloatingIPBinding.MaxStatusCandidates = configv1beta1.DefaultMaxStatusCandidates
+			},
+		},
+		{
+			name:       "unset flags keep values from the file",
+			configFile: true,
+			want: func(c *configv1beta1.FloatingIPControllerConfig) {
+				c.ControllerName = "example.com/from-file"
+				c.WatchNamespaces = []string{"file-a", "file-b"}
+				c.LeaseNamespace = "file-leases"
+				c.DigitalOcean.APIURL = "http://file.example.com"
+				c.FloatingIPBinding.DryRun = true
+				c.FloatingIPBinding.MaxStatusCandidates = 5
+			},
+		},
+		{
+			name:       "flags override the file",
+			configFile: true,
+			args: []string{
+				"--controller-name=example.com/from-flag",
+				"--watch-namespaces=flag-a",
+				"--lease-namespace=flag-leases",
+				"--do-api-url=http://flag.example.com",
+				"--dry-run=false",
+				"--max-status-candidates=20",
+			},
+			want: func(c *configv1beta1.FloatingIPControllerConfig) {
+				c.ControllerName = "example.com/from-flag"
+				c.WatchNamespaces = []string{"flag-a"}
+				c.LeaseNamespace = "flag-leases"
+				c.DigitalOcean.APIURL = "http://flag.example.com"
+				c.FloatingIPBinding.DryRun = false
+				c.FloatingIPBinding.MaxStatusCandidates = 20
+			},
+		},
+		{
+			name:       "flags set to their default still override the file",
+			configFile: true,
+			args:       []string{"--max-status-candidates=10"},
+			want: func(c *configv1beta1.FloatingIPControllerConfig) {
+				c.ControllerName = "example.com/from-file"
+				c.WatchNamespaces = []string{"file-a", "file-b"}
+				c.LeaseNamespace = "file-leases"
+				c.DigitalOcean.APIURL = "http://file.example.com"
+				c.FloatingIPBinding.DryRun = true
+				c.FloatingIPBinding.MaxStatusCandidates = 10
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			var overrides configFlags
+			overrides.bind(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got := configv1beta1.FloatingIPControllerConfig{}
+			if tt.configFile {
+				options := ctrl.Options{Scheme: scheme}
+				if _, err := options.AndFrom(ctrl.ConfigFile().AtPath(path).OfKind(&got)); err != nil {
+					t.Fatalf("failed to load the config file: %v", err)
+				}
+			}
+			overrides.apply(fs, &got)
+			got.Default()
+
+			want := configv1beta1.FloatingIPControllerConfig{}
+			want.Default()
+			tt.want(&want)
+			if !reflect.DeepEqual(got.ControllerName, want.ControllerName) ||
+				!reflect.DeepEqual(got.WatchNamespaces, want.WatchNamespaces) ||
+				got.LeaseNamespace != want.LeaseNamespace ||
+				!reflect.DeepEqual(got.DigitalOcean, want.DigitalOcean) ||
+				!reflect.DeepEqual(got.FloatingIPBinding, want.FloatingIPBinding) {
+				t.Errorf("config = %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
@@ -17,8 +17,13 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,12 +33,15 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
-	"github.com/digitalocean/godo"
+	configv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/config/v1beta1"
 	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
 	digitaloceancontrollers "github.com/smirl/digitalocean-floating-ip-controller/controllers/digitalocean"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/notify"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -46,11 +54,19 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(digitaloceanv1beta1.AddToScheme(scheme))
+	utilruntime.Must(configv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var configFile string
+	var tokenFile string
+	var overrides configFlags
+	flag.StringVar(&tokenFile, "do-token-file", "",
+		"Path to a file containing the DigitalOcean API token. "+
+			"The file is watched and the token reloaded when it changes. "+
+			"Omit this flag to read the token from the DO_TOKEN environment variable.")
+	overrides.bind(flag.CommandLine)
 	flag.StringVar(&configFile, "config", "",
 		"The controller will load its initial configuration from this file. "+
 			"Omit this flag to use the default configuration values. "+
@@ -61,24 +77,80 @@ func main() {
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	token, ok := os.LookupEnv("DO_TOKEN")
-	if !ok {
-		setupLog.Info("Could not find DO_TOKEN environment variable")
-		os.Exit(1)
-	}
-	doClient := godo.NewFromToken(token)
-
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
 	var err error
+	ctrlConfig := configv1beta1.FloatingIPControllerConfig{}
 	options := ctrl.Options{Scheme: scheme}
 	if configFile != "" {
-		options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile))
+		options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile).OfKind(&ctrlConfig))
 		if err != nil {
 			setupLog.Error(err, "unable to load the config file")
 			os.Exit(1)
 		}
 	}
+	// Command-line flags override configuration from the file
+	overrides.apply(flag.CommandLine, &ctrlConfig)
+	ctrlConfig.Default()
+	if err := ctrlConfig.Validate(); err != nil {
+		setupLog.Error(err, "unable to validate the config")
+		os.Exit(1)
+	}
+
+	// Restrict the cache to the watched namespaces. Nodes are cluster-scoped and still watched.
+	switch len(ctrlConfig.WatchNamespaces) {
+	case 0:
+	case 1:
+		options.Namespace = ctrlConfig.WatchNamespaces[0]
+	default:
+		options.NewCache = cache.MultiNamespacedCacheBuilder(ctrlConfig.WatchNamespaces)
+	}
+	if ctrlConfig.LeaseNamespace == "" {
+		ctrlConfig.LeaseNamespace = podNamespace()
+	}
+	// Instances with different controllerNames manage different bindings, so each elects its own leader
+	options.LeaderElectionID = leaderElectionID(options.LeaderElectionID, ctrlConfig.ControllerName)
+
+	var token string
+	if tokenFile != "" {
+		token, err = digitaloceancontrollers.ReadTokenFile(tokenFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read token file")
+			os.Exit(1)
+		}
+	} else {
+		var ok bool
+		token, ok = os.LookupEnv("DO_TOKEN")
+		if !ok {
+			setupLog.Info("Could not find DO_TOKEN environment variable")
+			os.Exit(1)
+		}
+	}
+	newProvider, err := provider.NewGodoProviderFunc(provider.GodoOptions{
+		APIURL: ctrlConfig.DigitalOcean.APIURL,
+		QPS:    ctrlConfig.DigitalOcean.QPS,
+		Burst:  ctrlConfig.DigitalOcean.Burst,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to parse DigitalOcean API URL")
+		os.Exit(1)
+	}
+	// Track the outcome of DigitalOcean API calls for the readyz check
+	apiHealth := &digitaloceancontrollers.APIHealth{}
+	newAccountProvider := func(token string) provider.FloatingIPProvider {
+		return provider.WithObserver(newProvider(token), apiHealth.ObserveAccount)
+	}
+	newBindingProvider := func(token string) provider.FloatingIPProvider {
+		return provider.WithObserver(newProvider(token), apiHealth.ObserveBinding)
+	}
+	doProvider := digitaloceancontrollers.NewProviderHolder(newAccountProvider(token))
+
+	validateCtx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	if err := doProvider.Validate(validateCtx); err != nil {
+		setupLog.Error(err, "unable to validate DigitalOcean token")
+		os.Exit(1)
+	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
@@ -86,17 +158,87 @@ func main() {
 		os.Exit(1)
 	}
 
+	notifier := notify.NewDispatcher(ctrl.Log.WithName("notify"))
+	if err := mgr.Add(notifier); err != nil {
+		setupLog.Error(err, "unable to set up notifications")
+		os.Exit(1)
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		setupLog.Error(err, "unable to get hostname")
+		os.Exit(1)
+	}
+	leases := &digitaloceancontrollers.ControllerLease{
+		Client:          mgr.GetClient(),
+		Reader:          mgr.GetAPIReader(),
+		Log:             ctrl.Log.WithName("lease"),
+		Namespace:       ctrlConfig.LeaseNamespace,
+		ControllerName:  ctrlConfig.ControllerName,
+		WatchNamespaces: ctrlConfig.WatchNamespaces,
+		Identity:        identity,
+	}
+	if err := mgr.Add(leases); err != nil {
+		setupLog.Error(err, "unable to set up controller lease")
+		os.Exit(1)
+	}
+
 	if err = (&digitaloceancontrollers.FloatingIPBindingReconciler{
-		Client:             mgr.GetClient(),
-		Log:                ctrl.Log.WithName("controllers").WithName("digitalocean").WithName("FloatingIPBinding"),
-		Scheme:             mgr.GetScheme(),
-		DigitaloceanClient: doClient,
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("digitalocean").WithName("FloatingIPBinding"),
+		Scheme:   mgr.GetScheme(),
+		Provider: doProvider,
+		Recorder: mgr.GetEventRecorderFor("floatingipbinding-controller"),
+		ProviderCache: digitaloceancontrollers.ProviderCache{
+			NewProvider: newBindingProvider,
+		},
+		APIReader:               mgr.GetAPIReader(),
+		DryRun:                  ctrlConfig.FloatingIPBinding.DryRun,
+		MaxCandidates:           ctrlConfig.FloatingIPBinding.MaxStatusCandidates,
+		Notifier:                notifier,
+		RequeueInterval:         ctrlConfig.FloatingIPBinding.RequeueInterval.Duration,
+		TagPollInterval:         ctrlConfig.FloatingIPBinding.DropletTagPollInterval.Duration,
+		MaxConcurrentReconciles: ctrlConfig.FloatingIPBinding.MaxConcurrentReconciles,
+		RetryBaseDelay:          ctrlConfig.FloatingIPBinding.RetryBaseDelay.Duration,
+		MaxRetryDelay:           ctrlConfig.FloatingIPBinding.MaxRetryDelay.Duration,
+		Freeze:                  ctrlConfig.FloatingIPBinding.Freeze,
+		ControllerName:          ctrlConfig.ControllerName,
+		Leases:                  leases,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "FloatingIPBinding")
 		os.Exit(1)
 	}
+	if err = (&digitaloceancontrollers.FloatingIPClaimReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("digitalocean").WithName("FloatingIPClaim"),
+		Scheme:   mgr.GetScheme(),
+		Provider: doProvider,
+		Recorder: mgr.GetEventRecorderFor("floatingipclaim-controller"),
+		ProviderCache: digitaloceancontrollers.ProviderCache{
+			NewProvider: newBindingProvider,
+		},
+		APIReader:       mgr.GetAPIReader(),
+		ControllerName:  ctrlConfig.ControllerName,
+		RequeueInterval: ctrlConfig.FloatingIPBinding.RequeueInterval.Duration,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "FloatingIPClaim")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
+	if tokenFile != "" {
+		if err := mgr.Add(&digitaloceancontrollers.TokenFileWatcher{
+			Path:        tokenFile,
+			Holder:      doProvider,
+			Log:         ctrl.Log.WithName("token"),
+			Token:       token,
+			NewProvider: newAccountProvider,
+		}); err != nil {
+			setupLog.Error(err, "unable to watch token file")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -105,6 +247,14 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("digitalocean", doProvider.Checker); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("digitalocean-api", apiHealth.Checker); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -112,3 +262,23 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// podNamespace returns the namespace the controller runs in, or default outside a cluster
+func podNamespace() string {
+	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// leaderElectionID returns the name of the leader election lock for the controllerName.
+// The default instance keeps id so existing deployments keep their lock, and other
+// instances get a suffix derived from their controllerName.
+func leaderElectionID(id string, controllerName string) string {
+	if id == "" || controllerName == digitaloceanv1beta1.DefaultControllerName {
+		return id
+	}
+	sum := sha256.Sum256([]byte(controllerName))
+	return id + "-" + hex.EncodeToString(sum[:])[:10]
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	digitaloceanv1beta1 "github.com/smirl/digitalocean-floating-ip-controller/apis/digitalocean/v1beta1"
+)
+
+func TestLeaderElectionID(t *testing.T) {
+	const id = "5f62cd76.smirlwebs.com"
+	if got := leaderElectionID(id, digitaloceanv1beta1.DefaultControllerName); got != id {
+		t.Errorf("leaderElectionID() for the default controllerName = %q, want %q", got, id)
+	}
+	if got := leaderElectionID("", "example.com/team-a"); got != "" {
+		t.Errorf("leaderElectionID() without an id = %q, want it left empty", got)
+	}
+	teamA := leaderElectionID(id, "example.com/team-a")
+	teamB := leaderElectionID(id, "example.com/team-b")
+	if teamA == id || teamB == id || teamA == teamB {
+		t.Errorf("leaderElectionID() = %q and %q, want distinct ids different from %q", teamA, teamB, id)
+	}
+	if again := leaderElectionID(id, "example.com/team-a"); again != teamA {
+		t.Errorf("leaderElectionID() = %q then %q, want it stable", teamA, again)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package egress routes egress traffic through a droplet's anchor gateway, so that it
+// leaves from the floating IP assigned to the droplet
+package egress
+
+import (
+	"fmt"
+	"net"
+)
+
+// DefaultTable is the routing table holding the route through the anchor gateway
+const DefaultTable = 100
+
+// DefaultPriority is the priority of the rules selecting traffic for the routing table.
+// The rules use this priority and the one after it, which must both be lower than the
+// main table's priority of 32766.
+const DefaultPriority = 100
+
+// Route is egress traffic routed through the anchor gateway
+type Route struct {
+	// The anchor IP of the droplet, used as the source address of routed traffic
+	AnchorIP string
+	// The anchor gateway
+	Gateway string
+	// The source CIDRs of the routed traffic
+	SourceCIDRs []string
+}
+
+// Router installs the policy routing for a Route
+type Router interface {
+	// Apply routes traffic as described by route, replacing any previous route.
+	// A nil route removes all routing.
+	Apply(route *Route) error
+}
+
+// parse validates route and returns its addresses
+func (r *Route) parse() (anchorIP net.IP, gateway net.IP, sources []*net.IPNet, err error) {
+	anchorIP = net.ParseIP(r.AnchorIP).To4()
+	if anchorIP == nil {
+		return nil, nil, nil, fmt.Errorf("invalid anchor IP %q", r.AnchorIP)
+	}
+	gateway = net.ParseIP(r.Gateway).To4()
+	if gateway == nil {
+		return nil, nil, nil, fmt.Errorf("invalid anchor gateway %q", r.Gateway)
+	}
+	for _, cidr := range r.SourceCIDRs {
+		_, source, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid source CIDR %q: %w", cidr, err)
+		}
+		sources = append(sources, source)
+	}
+	return anchorIP, gateway, sources, nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package egress
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// NetlinkRouter installs a default route through the anchor gateway in Table. Traffic
+// from each source CIDR first looks up the main table at Priority ignoring its default
+// route, so pod, Service and VPC traffic keep their routes, and only traffic which would
+// take the default route is sent to Table at Priority+1.
+type NetlinkRouter struct {
+	Table    int
+	Priority int
+}
+
+// NewRouter returns a Router for this host
+func NewRouter(table int, priority int) (Router, error) {
+	return &NetlinkRouter{Table: table, Priority: priority}, nil
+}
+
+func (r *NetlinkRouter) Apply(route *Route) error {
+	if route == nil {
+		if err := r.syncRules(nil); err != nil {
+			return err
+		}
+		return r.removeRoutes()
+	}
+
+	anchorIP, gateway, sources, err := route.parse()
+	if err != nil {
+		return err
+	}
+	link, err := linkWithAddress(anchorIP)
+	if err != nil {
+		return err
+	}
+	err = netlink.RouteReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Gw:        gateway,
+		Src:       anchorIP,
+		Table:     r.Table,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace route via %s: %w", gateway, err)
+	}
+	return r.syncRules(sources)
+}
+
+// syncRules adds the rules for each source and removes any other rules of the router
+func (r *NetlinkRouter) syncRules(sources []*net.IPNet) error {
+	rules, err := netlink.RuleList(netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	stale, missing := r.planRules(rules, sources)
+	for i := range stale {
+		if err := netlink.RuleDel(&stale[i]); err != nil {
+			return fmt.Errorf("failed to delete rule: %w", err)
+		}
+	}
+	for i := range missing {
+		if err := netlink.RuleAdd(&missing[i]); err != nil {
+			return fmt.Errorf("failed to add rule from %s: %w", missing[i].Src, err)
+		}
+	}
+	return nil
+}
+
+// planRules compares the existing rules with the rules wanted for the sources. It returns
+// the rules of the router which are no longer wanted and the wanted rules which are missing.
+func (r *NetlinkRouter) planRules(rules []netlink.Rule, sources []*net.IPNet) (stale []netlink.Rule, missing []netlink.Rule) {
+	wanted := r.wantedRules(sources)
+	existing := map[string]bool{}
+	for _, rule := range rules {
+		if !r.ownsRule(&rule) {
+			continue
+		}
+		key := ruleKey(&rule)
+		if _, ok := wanted[key]; ok && !existing[key] {
+			existing[key] = true
+			continue
+		}
+		stale = append(stale, rule)
+	}
+
+	for _, source := range sources {
+		for _, rule := range r.sourceRules(source) {
+			key := ruleKey(&rule)
+			if existing[key] {
+				continue
+			}
+			existing[key] = true
+			missing = append(missing, rule)
+		}
+	}
+	return stale, missing
+}
+
+// sourceRules returns the rules for traffic from source. The first looks up the main
+// table but suppresses its default route, the second sends what's left to Table.
+func (r *NetlinkRouter) sourceRules(source *net.IPNet) []netlink.Rule {
+	suppress := netlink.NewRule()
+	suppress.Family = netlink.FAMILY_V4
+	suppress.Src = source
+	suppress.Table = unix.RT_TABLE_MAIN
+	suppress.SuppressPrefixlen = 0
+	suppress.Priority = r.Priority
+
+	anchor := netlink.NewRule()
+	anchor.Family = netlink.FAMILY_V4
+	anchor.Src = source
+	anchor.Table = r.Table
+	anchor.Priority = r.Priority + 1
+	return []netlink.Rule{*suppress, *anchor}
+}
+
+// wantedRules returns the rules for every source by ruleKey
+func (r *NetlinkRouter) wantedRules(sources []*net.IPNet) map[string]netlink.Rule {
+	wanted := map[string]netlink.Rule{}
+	for _, source := range sources {
+		for _, rule := range r.sourceRules(source) {
+			wanted[ruleKey(&rule)] = rule
+		}
+	}
+	return wanted
+}
+
+// ownsRule returns true for rules installed by the router, including rules at Priority
+// which sent traffic straight to Table before the main table was looked up first
+func (r *NetlinkRouter) ownsRule(rule *netlink.Rule) bool {
+	if rule.Src == nil || (rule.Priority != r.Priority && rule.Priority != r.Priority+1) {
+		return false
+	}
+	return rule.Table == r.Table || (rule.Table == unix.RT_TABLE_MAIN && rule.SuppressPrefixlen == 0)
+}
+
+func ruleKey(rule *netlink.Rule) string {
+	return fmt.Sprintf("%d/%d/%s", rule.Priority, rule.Table, rule.Src)
+}
+
+// removeRoutes removes every route in the table
+func (r *NetlinkRouter) removeRoutes() error {
+	routes, err := netlink.RouteListFiltered(
+		netlink.FAMILY_V4, &netlink.Route{Table: r.Table}, netlink.RT_FILTER_TABLE,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %w", err)
+	}
+	for i := range routes {
+		if err := netlink.RouteDel(&routes[i]); err != nil {
+			return fmt.Errorf("failed to delete route: %w", err)
+		}
+	}
+	return nil
+}
+
+// linkWithAddress returns the link the IP address is assigned to
+func linkWithAddress(ip net.IP) (netlink.Link, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	for _, link := range links {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %w", link.Attrs().Name, err)
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return link, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no link has the anchor IP %s", ip)
+}
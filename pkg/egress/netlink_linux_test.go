@@ -0,0 +1,162 @@
+//go:build linux
+
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package egress
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func rule(src *net.IPNet, priority int, table int, suppress int) netlink.Rule {
+	r := netlink.NewRule()
+	r.Family = netlink.FAMILY_V4
+	r.Src = src
+	r.Priority = priority
+	r.Table = table
+	r.SuppressPrefixlen = suppress
+	return *r
+}
+
+func ruleKeys(rules []netlink.Rule) []string {
+	keys := []string{}
+	for i := range rules {
+		keys = append(keys, ruleKey(&rules[i]))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPlanRules(t *testing.T) {
+	router := &NetlinkRouter{Table: DefaultTable, Priority: DefaultPriority}
+	pods := mustCIDR(t, "10.244.0.0/16")
+	vpc := mustCIDR(t, "10.110.0.0/20")
+
+	tests := []struct {
+		name        string
+		existing    []netlink.Rule
+		sources     []*net.IPNet
+		wantStale   []string
+		wantMissing []string
+	}{
+		{
+			name:    "suppresses the main table's default route before the anchor table",
+			sources: []*net.IPNet{pods},
+			wantMissing: []string{
+				"100/254/10.244.0.0/16",
+				"101/100/10.244.0.0/16",
+			},
+		},
+		{
+			name: "keeps rules which are already installed",
+			existing: []netlink.Rule{
+				rule(pods, 100, unix.RT_TABLE_MAIN, 0),
+				rule(pods, 101, DefaultTable, -1),
+			},
+			sources: []*net.IPNet{pods},
+		},
+		{
+			name: "replaces rules sending all traffic to the anchor table",
+			existing: []netlink.Rule{
+				rule(pods, 100, DefaultTable, -1),
+			},
+			sources:   []*net.IPNet{pods},
+			wantStale: []string{"100/100/10.244.0.0/16"},
+			wantMissing: []string{
+				"100/254/10.244.0.0/16",
+				"101/100/10.244.0.0/16",
+			},
+		},
+		{
+			name: "removes rules for sources no longer routed",
+			existing: []netlink.Rule{
+				rule(pods, 100, unix.RT_TABLE_MAIN, 0),
+				rule(pods, 101, DefaultTable, -1),
+				rule(vpc, 100, unix.RT_TABLE_MAIN, 0),
+				rule(vpc, 101, DefaultTable, -1),
+			},
+			sources: []*net.IPNet{vpc},
+			wantStale: []string{
+				"100/254/10.244.0.0/16",
+				"101/100/10.244.0.0/16",
+			},
+		},
+		{
+			name: "leaves rules of other tables and priorities",
+			existing: []netlink.Rule{
+				rule(nil, 0, unix.RT_TABLE_LOCAL, -1),
+				rule(nil, 32766, unix.RT_TABLE_MAIN, -1),
+				rule(pods, 100, 200, -1),
+				rule(pods, 100, unix.RT_TABLE_MAIN, -1),
+				rule(pods, 50, DefaultTable, -1),
+			},
+			wantStale: []string{},
+		},
+		{
+			name: "removes duplicate rules",
+			existing: []netlink.Rule{
+				rule(pods, 100, unix.RT_TABLE_MAIN, 0),
+				rule(pods, 100, unix.RT_TABLE_MAIN, 0),
+				rule(pods, 101, DefaultTable, -1),
+			},
+			sources:   []*net.IPNet{pods},
+			wantStale: []string{"100/254/10.244.0.0/16"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stale, missing := router.planRules(tt.existing, tt.sources)
+			if got, want := ruleKeys(stale), append([]string{}, tt.wantStale...); !equal(got, want) {
+				t.Errorf("stale = %v, want %v", got, want)
+			}
+			if got, want := ruleKeys(missing), append([]string{}, tt.wantMissing...); !equal(got, want) {
+				t.Errorf("missing = %v, want %v", got, want)
+			}
+			for _, rule := range missing {
+				if rule.Table == unix.RT_TABLE_MAIN && rule.SuppressPrefixlen != 0 {
+					t.Errorf("main table rule %s doesn't suppress the default route", rule)
+				}
+			}
+		})
+	}
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakedo implements a fake DigitalOcean API server for development and testing
+package fakedo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// Server serves the floating IP, floating IP action, droplet, domain record, firewall
+// and account endpoints of the DigitalOcean API from the state held in a provider.Fake
+type Server struct {
+	Fake *provider.Fake
+	// Token, if set, is the only bearer token accepted
+	Token string
+}
+
+// NewServer returns a Server backed by fake
+func NewServer(fake *provider.Fake) *Server {
+	return &Server{Fake: fake}
+}
+
+type errorResponse struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+type floatingIPCreateRequest struct {
+	Region    string `json:"region"`
+	DropletID int    `json:"droplet_id,omitempty"`
+}
+
+type actionRequest struct {
+	Type      string `json:"type"`
+	DropletID int    `json:"droplet_id,omitempty"`
+}
+
+type firewallDropletsRequest struct {
+	DropletIDs []int `json:"droplet_ids"`
+}
+
+type dropletCreateRequest struct {
+	Name   string   `json:"name"`
+	Region string   `json:"region"`
+	Size   string   `json:"size"`
+	Tags   []string `json:"tags"`
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	id := strings.ToLower(strings.ReplaceAll(http.StatusText(statusCode), " ", "_"))
+	writeJSON(w, statusCode, errorResponse{ID: id, Message: message})
+}
+
+// writeProviderError writes err using the status code of a provider.StatusError
+func writeProviderError(w http.ResponseWriter, err error) {
+	var statusErr *provider.StatusError
+	if errors.As(err, &statusErr) {
+		writeError(w, statusErr.StatusCode, statusErr.Message)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || (s.Token != "" && auth != "Bearer "+s.Token) {
+		writeError(w, http.StatusUnauthorized, "Unable to authenticate you.")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v2" {
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+		return
+	}
+
+	switch parts[1] {
+	case "account":
+		s.serveAccount(w, r, parts[2:])
+	case "floating_ips":
+		s.serveFloatingIPs(w, r, parts[2:])
+	case "droplets":
+		s.serveDroplets(w, r, parts[2:])
+	case "domains":
+		s.serveDomains(w, r, parts[2:])
+	case "firewalls":
+		s.serveFirewalls(w, r, parts[2:])
+	default:
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+	}
+}
+
+func (s *Server) serveAccount(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) != 0 || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+		return
+	}
+	if err := s.Fake.Validate(r.Context()); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"account": godo.Account{
+			DropletLimit:    25,
+			FloatingIPLimit: 5,
+			Email:           "fake@example.com",
+			UUID:            "fake",
+			EmailVerified:   true,
+			Status:          "active",
+		},
+	})
+}
+
+func (s *Server) serveFloatingIPs(w http.ResponseWriter, r *http.Request, parts []string) {
+	ctx := r.Context()
+	switch {
+	// /v2/floating_ips
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		floatingIPs, err := s.Fake.ListFloatingIPs(ctx)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"floating_ips": floatingIPs,
+			"meta":         godo.Meta{Total: len(floatingIPs)},
+		})
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		var req floatingIPCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		floatingIP, err := s.Fake.CreateFloatingIP(ctx, req.Region)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		if req.DropletID != 0 {
+			if _, err := s.Fake.AssignFloatingIP(ctx, floatingIP.IP, req.DropletID); err != nil {
+				writeProviderError(w, err)
+				return
+			}
+		}
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"floating_ip": floatingIP})
+
+	// /v2/floating_ips/{ip}
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		floatingIP, err := s.Fake.GetFloatingIP(ctx, parts[0])
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"floating_ip": floatingIP})
+
+	// /v2/floating_ips/{ip}/actions
+	case len(parts) == 2 && parts[1] == "actions" && r.Method == http.MethodPost:
+		var req actionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var action *godo.Action
+		var err error
+		switch req.Type {
+		case "assign":
+			action, err = s.Fake.AssignFloatingIP(ctx, parts[0], req.DropletID)
+		case "unassign":
+			action, err = s.Fake.UnassignFloatingIP(ctx, parts[0])
+		default:
+			writeError(w, http.StatusUnprocessableEntity, "Unknown action type "+req.Type)
+			return
+		}
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"action": action})
+
+	// /v2/floating_ips/{ip}/actions/{id}
+	case len(parts) == 3 && parts[1] == "actions" && r.Method == http.MethodGet:
+		actionID, err := strconv.Atoi(parts[2])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+			return
+		}
+		action, err := s.Fake.GetAction(ctx, parts[0], actionID)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"action": action})
+
+	default:
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+	}
+}
+
+func (s *Server) serveDroplets(w http.ResponseWriter, r *http.Request, parts []string) {
+	ctx := r.Context()
+	switch {
+	// /v2/droplets
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		var droplets []godo.Droplet
+		var err error
+		if tag := r.URL.Query().Get("tag_name"); tag != "" {
+			droplets, err = s.Fake.ListDropletsByTag(ctx, tag)
+		} else {
+			droplets, err = s.Fake.ListDroplets(ctx)
+		}
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"droplets": droplets,
+			"meta":     godo.Meta{Total: len(droplets)},
+		})
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		var req dropletCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		droplet := s.Fake.CreateDroplet(godo.Droplet{
+			Name:     req.Name,
+			Region:   &godo.Region{Slug: req.Region},
+			SizeSlug: req.Size,
+			Tags:     req.Tags,
+		})
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"droplet": droplet})
+
+	// /v2/droplets/{id}
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		dropletID, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+			return
+		}
+		droplet, err := s.Fake.GetDroplet(ctx, dropletID)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"droplet": droplet})
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		dropletID, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+			return
+		}
+		if _, err := s.Fake.GetDroplet(ctx, dropletID); err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		s.Fake.RemoveDroplet(dropletID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+	}
+}
+
+func (s *Server) serveDomains(w http.ResponseWriter, r *http.Request, parts []string) {
+	ctx := r.Context()
+	if len(parts) < 2 || parts[1] != "records" {
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+		return
+	}
+	domain := parts[0]
+	switch {
+	// /v2/domains/{domain}/records
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		records, err := s.Fake.ListDomainRecords(ctx, domain)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"domain_records": records,
+			"meta":           godo.Meta{Total: len(records)},
+		})
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		var req godo.DomainRecordEditRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		record, err := s.Fake.CreateDomainRecord(ctx, domain, &req)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"domain_record": record})
+
+	// /v2/domains/{domain}/records/{id}
+	case len(parts) == 3 && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		recordID, err := strconv.Atoi(parts[2])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+			return
+		}
+		var req godo.DomainRecordEditRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		record, err := s.Fake.EditDomainRecord(ctx, domain, recordID, &req)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"domain_record": record})
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		recordID, err := strconv.Atoi(parts[2])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+			return
+		}
+		if err := s.Fake.DeleteDomainRecord(ctx, domain, recordID); err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+	}
+}
+
+func (s *Server) serveFirewalls(w http.ResponseWriter, r *http.Request, parts []string) {
+	ctx := r.Context()
+	switch {
+	// /v2/firewalls/{id}
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		firewall, err := s.Fake.GetFirewall(ctx, parts[0])
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"firewall": firewall})
+
+	// /v2/firewalls/{id}/droplets
+	case len(parts) == 2 && parts[1] == "droplets" &&
+		(r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		var req firewallDropletsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var err error
+		if r.Method == http.MethodPost {
+			err = s.Fake.AddDropletsToFirewall(ctx, parts[0], req.DropletIDs...)
+		} else {
+			err = s.Fake.RemoveDropletsFromFirewall(ctx, parts[0], req.DropletIDs...)
+		}
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotFound, "The resource you were accessing could not be found.")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakedo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/digitalocean/godo"
+
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/fakedo"
+	"github.com/smirl/digitalocean-floating-ip-controller/pkg/provider"
+)
+
+// newClient serves fake through a Server and returns a godo provider using token
+func newClient(t *testing.T, server *fakedo.Server, token string) provider.FloatingIPProvider {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	newProvider, err := provider.NewGodoProviderFunc(provider.GodoOptions{APIURL: httpServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newProvider(token)
+}
+
+func TestServerAuthentication(t *testing.T) {
+	ctx := context.Background()
+	server := fakedo.NewServer(provider.NewFake())
+	server.Token = "secret"
+
+	if err := newClient(t, server, "wrong").Validate(ctx); !provider.HasStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Validate() with the wrong token error = %v, want a 401", err)
+	}
+	if err := newClient(t, server, "secret").Validate(ctx); err != nil {
+		t.Errorf("Validate() with the right token error = %v", err)
+	}
+
+	// Requests without a bearer token are rejected
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	resp, err := http.Get(httpServer.URL + "/v2/account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status without a token = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestServerFloatingIPs(t *testing.T) {
+	ctx := context.Background()
+	fake := provider.NewFake()
+	fake.AddDroplet(godo.Droplet{ID: 1, Name: "node1"})
+	fake.AddFloatingIP("1.2.3.4", 0)
+	client := newClient(t, fakedo.NewServer(fake), "token")
+
+	action, err := client.AssignFloatingIP(ctx, "1.2.3.4", 1)
+	if err != nil {
+		t.Fatalf("AssignFloatingIP() error = %v", err)
+	}
+	if _, err := client.GetAction(ctx, "1.2.3.4", action.ID); err != nil {
+		t.Fatalf("GetAction() error = %v", err)
+	}
+	floatingIP, err := client.GetFloatingIP(ctx, "1.2.3.4")
+	if err != nil {
+		t.Fatalf("GetFloatingIP() error = %v", err)
+	}
+	if floatingIP.Droplet == nil || floatingIP.Droplet.ID != 1 {
+		t.Errorf("GetFloatingIP() droplet = %v, want droplet 1", floatingIP.Droplet)
+	}
+
+	created, err := client.CreateFloatingIP(ctx, "lon1")
+	if err != nil {
+		t.Fatalf("CreateFloatingIP() error = %v", err)
+	}
+	floatingIPs, err := client.ListFloatingIPs(ctx)
+	if err != nil {
+		t.Fatalf("ListFloatingIPs() error = %v", err)
+	}
+	if len(floatingIPs) != 2 {
+		t.Errorf("ListFloatingIPs() returned %d floating IPs, want 2 including %s", len(floatingIPs), created.IP)
+	}
+
+	if _, err := client.GetFloatingIP(ctx, "9.9.9.9"); !provider.IsNotFound(err) {
+		t.Errorf("GetFloatingIP() of an unknown IP error = %v, want a 404", err)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	ctx := context.Background()
+	fake := provider.NewFake()
+	fake.AddDroplet(godo.Droplet{ID: 1})
+	fake.AddFloatingIP("1.2.3.4", 0)
+	client := newClient(t, fakedo.NewServer(fake), "token")
+
+	// Injected StatusErrors keep their status code through the HTTP API
+	fake.FailNext("AssignFloatingIP", &provider.StatusError{StatusCode: 422, Message: "locked"})
+	if _, err := client.AssignFloatingIP(ctx, "1.2.3.4", 1); !provider.IsPending(err) {
+		t.Errorf("AssignFloatingIP() error = %v, want a 422", err)
+	}
+	fake.FailNext("ListDroplets", &provider.StatusError{StatusCode: 503, Message: "unavailable"})
+	if _, err := client.ListDroplets(ctx); !provider.HasStatus(err, http.StatusServiceUnavailable) {
+		t.Errorf("ListDroplets() error = %v, want a 503", err)
+	}
+	if _, err := client.ListDroplets(ctx); err != nil {
+		t.Errorf("ListDroplets() error = %v once the fault is used", err)
+	}
+}
+
+func TestServerDropletsByTag(t *testing.T) {
+	ctx := context.Background()
+	fake := provider.NewFake()
+	fake.AddDroplet(godo.Droplet{ID: 1, Tags: []string{"edge"}})
+	fake.AddDroplet(godo.Droplet{ID: 2})
+	client := newClient(t, fakedo.NewServer(fake), "token")
+
+	droplets, err := client.ListDropletsByTag(ctx, "edge")
+	if err != nil {
+		t.Fatalf("ListDropletsByTag() error = %v", err)
+	}
+	if len(droplets) != 1 || droplets[0].ID != 1 {
+		t.Errorf("ListDropletsByTag() = %v, want droplet 1", droplets)
+	}
+	if _, err := client.GetDroplet(ctx, 3); !provider.IsNotFound(err) {
+		t.Errorf("GetDroplet() of an unknown droplet error = %v, want a 404", err)
+	}
+}
+
+func TestServerDomainRecords(t *testing.T) {
+	ctx := context.Background()
+	fake := provider.NewFake()
+	fake.AddDomain("example.com")
+	client := newClient(t, fakedo.NewServer(fake), "token")
+
+	record, err := client.CreateDomainRecord(ctx, "example.com",
+		&godo.DomainRecordEditRequest{Type: "A", Name: "www", Data: "1.2.3.4", TTL: 1800})
+	if err != nil {
+		t.Fatalf("CreateDomainRecord() error = %v", err)
+	}
+	if _, err := client.EditDomainRecord(ctx, "example.com", record.ID,
+		&godo.DomainRecordEditRequest{Type: "A", Name: "www", Data: "5.6.7.8", TTL: 1800}); err != nil {
+		t.Fatalf("EditDomainRecord() error = %v", err)
+	}
+	records, err := client.ListDomainRecords(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("ListDomainRecords() error = %v", err)
+	}
+	if len(records) != 1 || records[0].Data != "5.6.7.8" {
+		t.Errorf("ListDomainRecords() = %v, want the edited record", records)
+	}
+	if err := client.DeleteDomainRecord(ctx, "example.com", record.ID); err != nil {
+		t.Fatalf("DeleteDomainRecord() error = %v", err)
+	}
+	if err := client.DeleteDomainRecord(ctx, "example.com", record.ID); !provider.IsNotFound(err) {
+		t.Errorf("DeleteDomainRecord() of a deleted record error = %v, want a 404", err)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify delivers webhook notifications about floating IP bindings
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// SignatureHeader holds the HMAC-SHA256 signature of the payload, e.g. sha256=<hex>
+const SignatureHeader = "X-Signature-256"
+
+// QueueSize is the number of deliveries which can wait to be sent
+const QueueSize = 100
+
+// Workers is the number of deliveries sent concurrently
+const Workers = 4
+
+// Payload is the JSON body POSTed for each notification
+type Payload struct {
+	Event      string     `json:"event"`
+	Binding    BindingRef `json:"binding"`
+	FloatingIP string     `json:"floatingIP"`
+	OldDroplet *Droplet   `json:"oldDroplet,omitempty"`
+	NewDroplet *Droplet   `json:"newDroplet,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	Timestamp  time.Time  `json:"timestamp"`
+}
+
+// BindingRef identifies a FloatingIPBinding
+type BindingRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Droplet identifies a droplet
+type Droplet struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// Delivery is a Payload to send to a URL
+type Delivery struct {
+	URL string
+	// Secret signs the payload when set
+	Secret []byte
+	// MaxRetries is the number of times a failed delivery is retried
+	MaxRetries int
+	Payload    Payload
+}
+
+// Sign returns the value of the SignatureHeader for body
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends deliveries in the background, retrying failures with exponential
+// backoff. It is a manager Runnable.
+type Dispatcher struct {
+	Client *http.Client
+	Log    logr.Logger
+	// BaseDelay is the delay before the first retry, doubling for each retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	queue chan Delivery
+	once  sync.Once
+}
+
+// NewDispatcher returns a Dispatcher with default settings
+func NewDispatcher(log logr.Logger) *Dispatcher {
+	return &Dispatcher{
+		Client:    &http.Client{Timeout: time.Second * 10},
+		Log:       log,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+	}
+}
+
+func (d *Dispatcher) init() {
+	d.once.Do(func() {
+		d.queue = make(chan Delivery, QueueSize)
+	})
+}
+
+// Enqueue queues a delivery without blocking. The delivery is dropped if the queue is full.
+func (d *Dispatcher) Enqueue(delivery Delivery) {
+	d.init()
+	select {
+	case d.queue <- delivery:
+	default:
+		d.Log.Info("Notification queue is full. Dropping notification.",
+			"url", delivery.URL, "event", delivery.Payload.Event)
+	}
+}
+
+// Start sends queued deliveries until ctx is done
+func (d *Dispatcher) Start(ctx context.Context) error {
+	d.init()
+	var wg sync.WaitGroup
+	for i := 0; i < Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-d.queue:
+					if err := d.Deliver(ctx, delivery); err != nil {
+						d.Log.Error(err, "Failed to deliver notification",
+							"url", delivery.URL, "event", delivery.Payload.Event)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Deliver sends a delivery, retrying failures
+func (d *Dispatcher) Deliver(ctx context.Context, delivery Delivery) error {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return err
+	}
+	delay := d.BaseDelay
+	for attempt := 0; ; attempt++ {
+		err = d.send(ctx, delivery, body)
+		if err == nil || attempt >= delivery.MaxRetries {
+			return err
+		}
+		d.Log.Info("Notification failed. Retrying.", "url", delivery.URL, "attempt", attempt+1, "error", err.Error())
+
+		// Wait with jitter so retries to the same URL are spread out
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+		if delay > d.MaxDelay {
+			delay = d.MaxDelay
+		}
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery Delivery, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(delivery.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(delivery.Secret, body))
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 test case 2 from RFC 4231
+	got := Sign([]byte("Jefe"), []byte("what do ya want for nothing?"))
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+}
+
+// receiver is a webhook which fails the first failures requests
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, req)
+	rc.bodies = append(rc.bodies, body)
+	rc.times = append(rc.times, time.Now())
+	if len(rc.requests) <= rc.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+func testDispatcher(baseDelay time.Duration, maxDelay time.Duration) *Dispatcher {
+	d := NewDispatcher(logr.Discard())
+	d.BaseDelay = baseDelay
+	d.MaxDelay = maxDelay
+	return d
+}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		maxRetries   int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "delivered first time", maxRetries: 3, wantRequests: 1},
+		{name: "retried until delivered", failures: 2, maxRetries: 3, wantRequests: 3},
+		{name: "retries exhausted", failures: 10, maxRetries: 2, wantErr: true, wantRequests: 3},
+		{name: "not retried", failures: 1, maxRetries: 0, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{failures: tt.failures}
+			server := httptest.NewServer(rc)
+			defer server.Close()
+
+			d := testDispatcher(time.Millisecond, time.Millisecond*10)
+			err := d.Deliver(context.Background(), Delivery{
+				URL:        server.URL,
+				Secret:     []byte("secret"),
+				MaxRetries: tt.maxRetries,
+				Payload:    Payload{Event: "Assigned", FloatingIP: "203.0.113.1"},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := rc.count(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			for i, req := range rc.requests {
+				if got, want := req.Header.Get(SignatureHeader), Sign([]byte("secret"), rc.bodies[i]); got != want {
+					t.Errorf("request %d signature = %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverBacksOff(t *testing.T) {
+	rc := &receiver{failures: 3}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	base := time.Millisecond * 40
+	d := testDispatcher(base, base*2)
+	if err := d.Deliver(context.Background(), Delivery{URL: server.URL, MaxRetries: 3}); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+
+	// Each wait is between half and all of the delay, which doubles up to MaxDelay
+	delays := []time.Duration{base, base * 2, base * 2}
+	for i, delay := range delays {
+		wait := rc.times[i+1].Sub(rc.times[i])
+		if wait < delay/2 {
+			t.Errorf("retry %d waited %s, want at least %s", i+1, wait, delay/2)
+		}
+	}
+	if unsigned := rc.requests[0].Header.Get(SignatureHeader); unsigned != "" {
+		t.Errorf("signature = %s without a secret, want none", unsigned)
+	}
+}
+
+func TestDeliverStopsWhenCancelled(t *testing.T) {
+	rc := &receiver{failures: 10}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := testDispatcher(time.Hour, time.Hour)
+	done := make(chan error)
+	go func() {
+		done <- d.Deliver(ctx, Delivery{URL: server.URL, MaxRetries: 5})
+	}()
+	for rc.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Deliver() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Deliver() didn't return when cancelled")
+	}
+}
+
+func TestEnqueueDropsWhenFull(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d := testDispatcher(time.Millisecond, time.Millisecond)
+	for i := 0; i < QueueSize+10; i++ {
+		d.Enqueue(Delivery{URL: server.URL, Payload: Payload{Event: "Assigned"}})
+	}
+	if got := len(d.queue); got != QueueSize {
+		t.Fatalf("queued = %d, want %d", got, QueueSize)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = d.Start(ctx)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(time.Second * 5)
+	for rc.count() < QueueSize && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	cancel()
+	<-stopped
+	if got := rc.count(); got != QueueSize {
+		t.Errorf("delivered = %d, want %d", got, QueueSize)
+	}
+}
@@ -0,0 +1,582 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// fakeIPRangeSize is the number of addresses, 203.0.113.1-254, CreateFloatingIP allocates from
+const fakeIPRangeSize = 254
+
+// Fake is a stateful in-memory FloatingIPProvider for tests. Faults can be
+// injected for any method by name, e.g. "AssignFloatingIP".
+type Fake struct {
+	// ActionDuration is how long actions stay in-progress. While an action is
+	// in-progress the floating IP is locked and further actions fail with a 422.
+	ActionDuration time.Duration
+	// Region is used for floating IPs created without a region
+	Region string
+
+	mu           sync.Mutex
+	floatingIPs  map[string]*godo.FloatingIP
+	droplets     map[int]*godo.Droplet
+	actions      map[int]*fakeAction
+	nextActionID int
+	nextIP       int
+	nextDroplet  int
+	domains      map[string]map[int]*godo.DomainRecord
+	nextRecord   int
+	firewalls    map[string]*godo.Firewall
+	faults       map[string][]error
+	stickyFaults map[string]error
+	calls        map[string]int
+}
+
+type fakeAction struct {
+	action    *godo.Action
+	ip        string
+	dropletID int
+	finishAt  time.Time
+}
+
+var _ FloatingIPProvider = &Fake{}
+
+// NewFake returns an empty Fake
+func NewFake() *Fake {
+	f := &Fake{}
+	f.Reset()
+	return f
+}
+
+// Reset removes all state, faults and recorded calls
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.floatingIPs = map[string]*godo.FloatingIP{}
+	f.droplets = map[int]*godo.Droplet{}
+	f.actions = map[int]*fakeAction{}
+	f.domains = map[string]map[int]*godo.DomainRecord{}
+	f.firewalls = map[string]*godo.Firewall{}
+	f.faults = map[string][]error{}
+	f.stickyFaults = map[string]error{}
+	f.calls = map[string]int{}
+}
+
+// AddDroplet adds a droplet which floating IPs can be assigned to
+func (f *Fake) AddDroplet(droplet godo.Droplet) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.droplets[droplet.ID] = &droplet
+}
+
+// CreateDroplet adds a droplet with a new ID and creation time
+func (f *Fake) CreateDroplet(droplet godo.Droplet) *godo.Droplet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for droplet.ID == 0 || f.droplets[droplet.ID] != nil {
+		f.nextDroplet++
+		droplet.ID = f.nextDroplet
+	}
+	if droplet.Created == "" {
+		droplet.Created = time.Now().UTC().Format(time.RFC3339)
+	}
+	if droplet.Status == "" {
+		droplet.Status = "active"
+	}
+	f.droplets[droplet.ID] = &droplet
+	copied := droplet
+	return &copied
+}
+
+// ListDroplets returns every droplet ordered by ID
+func (f *Fake) ListDroplets(ctx context.Context) ([]godo.Droplet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ListDroplets"); err != nil {
+		return nil, err
+	}
+	droplets := make([]godo.Droplet, 0, len(f.droplets))
+	for _, droplet := range f.droplets {
+		droplets = append(droplets, *droplet)
+	}
+	sort.Slice(droplets, func(i, j int) bool { return droplets[i].ID < droplets[j].ID })
+	return droplets, nil
+}
+
+// ListDropletsByTag returns every droplet with a tag ordered by ID
+func (f *Fake) ListDropletsByTag(ctx context.Context, tag string) ([]godo.Droplet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ListDropletsByTag"); err != nil {
+		return nil, err
+	}
+	droplets := []godo.Droplet{}
+	for _, droplet := range f.droplets {
+		for _, t := range droplet.Tags {
+			if t == tag {
+				droplets = append(droplets, *droplet)
+				break
+			}
+		}
+	}
+	sort.Slice(droplets, func(i, j int) bool { return droplets[i].ID < droplets[j].ID })
+	return droplets, nil
+}
+
+// RemoveDroplet removes a droplet, unassigning any floating IPs from it
+func (f *Fake) RemoveDroplet(dropletID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.droplets, dropletID)
+	for _, floatingIP := range f.floatingIPs {
+		if floatingIP.Droplet != nil && floatingIP.Droplet.ID == dropletID {
+			floatingIP.Droplet = nil
+		}
+	}
+}
+
+// AddFloatingIP adds a floating IP assigned to dropletID, or unassigned if dropletID is 0
+func (f *Fake) AddFloatingIP(ip string, dropletID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	floatingIP := &godo.FloatingIP{IP: ip, Region: &godo.Region{Slug: f.Region}}
+	if dropletID != 0 {
+		floatingIP.Droplet = f.dropletOrStub(dropletID)
+	}
+	f.floatingIPs[ip] = floatingIP
+}
+
+// AddDomain adds a domain without any records
+func (f *Fake) AddDomain(domain string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.domains[domain]; !ok {
+		f.domains[domain] = map[int]*godo.DomainRecord{}
+	}
+}
+
+// AddFirewall adds a Cloud Firewall without any droplets
+func (f *Fake) AddFirewall(firewallID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.firewalls[firewallID] = &godo.Firewall{ID: firewallID, Name: firewallID, Status: "succeeded"}
+}
+
+// AssignedDropletID returns the ID of the droplet a floating IP is assigned to, or 0
+func (f *Fake) AssignedDropletID(ip string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.completeActions()
+	floatingIP, ok := f.floatingIPs[ip]
+	if !ok || floatingIP.Droplet == nil {
+		return 0
+	}
+	return floatingIP.Droplet.ID
+}
+
+// FailNext makes the next call to method return err
+func (f *Fake) FailNext(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[method] = append(f.faults[method], err)
+}
+
+// Fail makes every call to method return err until ClearFaults is called
+func (f *Fake) Fail(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stickyFaults[method] = err
+}
+
+// ClearFaults removes all injected faults
+func (f *Fake) ClearFaults() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = map[string][]error{}
+	f.stickyFaults = map[string]error{}
+}
+
+// Calls returns the number of times method has been called
+func (f *Fake) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// call records a call to method and returns any injected fault. The lock must be held.
+func (f *Fake) call(method string) error {
+	f.calls[method]++
+	f.completeActions()
+	if queued := f.faults[method]; len(queued) > 0 {
+		f.faults[method] = queued[1:]
+		return queued[0]
+	}
+	return f.stickyFaults[method]
+}
+
+// completeActions applies any in-progress actions which have finished. The lock must be held.
+func (f *Fake) completeActions() {
+	now := time.Now()
+	for _, a := range f.actions {
+		if a.action.Status != godo.ActionInProgress || now.Before(a.finishAt) {
+			continue
+		}
+		a.action.Status = godo.ActionCompleted
+		a.action.CompletedAt = &godo.Timestamp{Time: now}
+		if floatingIP, ok := f.floatingIPs[a.ip]; ok {
+			if a.dropletID == 0 {
+				floatingIP.Droplet = nil
+			} else {
+				floatingIP.Droplet = f.dropletOrStub(a.dropletID)
+			}
+		}
+	}
+}
+
+// locked returns true when the floating IP has an in-progress action. The lock must be held.
+func (f *Fake) locked(ip string) bool {
+	for _, a := range f.actions {
+		if a.ip == ip && a.action.Status == godo.ActionInProgress {
+			return true
+		}
+	}
+	return false
+}
+
+// dropletOrStub returns a copy of a known droplet or a stub with only the ID. The lock must be held.
+func (f *Fake) dropletOrStub(dropletID int) *godo.Droplet {
+	if droplet, ok := f.droplets[dropletID]; ok {
+		copied := *droplet
+		return &copied
+	}
+	return &godo.Droplet{ID: dropletID}
+}
+
+// startAction starts an action on a floating IP. The lock must be held.
+func (f *Fake) startAction(ip string, actionType string, dropletID int) *godo.Action {
+	f.nextActionID++
+	now := time.Now()
+	action := &godo.Action{
+		ID:           f.nextActionID,
+		Status:       godo.ActionInProgress,
+		Type:         actionType,
+		StartedAt:    &godo.Timestamp{Time: now},
+		ResourceType: "floating_ip",
+	}
+	f.actions[action.ID] = &fakeAction{
+		action:    action,
+		ip:        ip,
+		dropletID: dropletID,
+		finishAt:  now.Add(f.ActionDuration),
+	}
+	f.completeActions()
+	copied := *action
+	return &copied
+}
+
+func notFound(format string, a ...interface{}) error {
+	return &StatusError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf(format, a...)}
+}
+
+func (f *Fake) Validate(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.call("Validate")
+}
+
+func (f *Fake) GetFloatingIP(ctx context.Context, ip string) (*godo.FloatingIP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetFloatingIP"); err != nil {
+		return nil, err
+	}
+	floatingIP, ok := f.floatingIPs[ip]
+	if !ok {
+		return nil, notFound("floating IP %s not found", ip)
+	}
+	copied := *floatingIP
+	return &copied, nil
+}
+
+func (f *Fake) ListFloatingIPs(ctx context.Context) ([]godo.FloatingIP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ListFloatingIPs"); err != nil {
+		return nil, err
+	}
+	floatingIPs := make([]godo.FloatingIP, 0, len(f.floatingIPs))
+	for _, floatingIP := range f.floatingIPs {
+		floatingIPs = append(floatingIPs, *floatingIP)
+	}
+	sort.Slice(floatingIPs, func(i, j int) bool { return floatingIPs[i].IP < floatingIPs[j].IP })
+	return floatingIPs, nil
+}
+
+func (f *Fake) CreateFloatingIP(ctx context.Context, region string) (*godo.FloatingIP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateFloatingIP"); err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = f.Region
+	}
+	// Allocate addresses from the TEST-NET-3 range, failing once every address is taken
+	var ip string
+	for tries := 0; ip == "" || f.floatingIPs[ip] != nil; tries++ {
+		if tries == fakeIPRangeSize {
+			return nil, &StatusError{
+				StatusCode: http.StatusUnprocessableEntity,
+				Message:    "no floating IPs left to allocate",
+			}
+		}
+		f.nextIP = f.nextIP%fakeIPRangeSize + 1
+		ip = net.IPv4(203, 0, 113, byte(f.nextIP)).String()
+	}
+	floatingIP := &godo.FloatingIP{IP: ip, Region: &godo.Region{Slug: region}}
+	f.floatingIPs[ip] = floatingIP
+	copied := *floatingIP
+	return &copied, nil
+}
+
+func (f *Fake) AssignFloatingIP(ctx context.Context, ip string, dropletID int) (*godo.Action, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("AssignFloatingIP"); err != nil {
+		return nil, err
+	}
+	if _, ok := f.floatingIPs[ip]; !ok {
+		return nil, notFound("floating IP %s not found", ip)
+	}
+	if _, ok := f.droplets[dropletID]; !ok {
+		return nil, notFound("droplet %d not found", dropletID)
+	}
+	if f.locked(ip) {
+		return nil, &StatusError{StatusCode: http.StatusUnprocessableEntity, Message: "floating IP is locked by an in-progress action"}
+	}
+	return f.startAction(ip, "assign_ip", dropletID), nil
+}
+
+func (f *Fake) UnassignFloatingIP(ctx context.Context, ip string) (*godo.Action, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("UnassignFloatingIP"); err != nil {
+		return nil, err
+	}
+	if _, ok := f.floatingIPs[ip]; !ok {
+		return nil, notFound("floating IP %s not found", ip)
+	}
+	if f.locked(ip) {
+		return nil, &StatusError{StatusCode: http.StatusUnprocessableEntity, Message: "floating IP is locked by an in-progress action"}
+	}
+	return f.startAction(ip, "unassign_ip", 0), nil
+}
+
+func (f *Fake) GetAction(ctx context.Context, ip string, actionID int) (*godo.Action, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetAction"); err != nil {
+		return nil, err
+	}
+	a, ok := f.actions[actionID]
+	if !ok || a.ip != ip {
+		return nil, notFound("action %d not found", actionID)
+	}
+	copied := *a.action
+	return &copied, nil
+}
+
+func (f *Fake) GetDroplet(ctx context.Context, dropletID int) (*godo.Droplet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetDroplet"); err != nil {
+		return nil, err
+	}
+	droplet, ok := f.droplets[dropletID]
+	if !ok {
+		return nil, notFound("droplet %d not found", dropletID)
+	}
+	copied := *droplet
+	return &copied, nil
+}
+
+func (f *Fake) ListDomainRecords(ctx context.Context, domain string) ([]godo.DomainRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ListDomainRecords"); err != nil {
+		return nil, err
+	}
+	records, ok := f.domains[domain]
+	if !ok {
+		return nil, notFound("domain %s not found", domain)
+	}
+	list := make([]godo.DomainRecord, 0, len(records))
+	for _, record := range records {
+		list = append(list, *record)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (f *Fake) CreateDomainRecord(
+	ctx context.Context,
+	domain string,
+	req *godo.DomainRecordEditRequest,
+) (*godo.DomainRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateDomainRecord"); err != nil {
+		return nil, err
+	}
+	records, ok := f.domains[domain]
+	if !ok {
+		return nil, notFound("domain %s not found", domain)
+	}
+	f.nextRecord++
+	record := &godo.DomainRecord{ID: f.nextRecord}
+	editRecord(record, req)
+	records[record.ID] = record
+	copied := *record
+	return &copied, nil
+}
+
+func (f *Fake) EditDomainRecord(
+	ctx context.Context,
+	domain string,
+	recordID int,
+	req *godo.DomainRecordEditRequest,
+) (*godo.DomainRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("EditDomainRecord"); err != nil {
+		return nil, err
+	}
+	record, ok := f.domains[domain][recordID]
+	if !ok {
+		return nil, notFound("domain record %d not found", recordID)
+	}
+	editRecord(record, req)
+	copied := *record
+	return &copied, nil
+}
+
+func (f *Fake) DeleteDomainRecord(ctx context.Context, domain string, recordID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteDomainRecord"); err != nil {
+		return err
+	}
+	if _, ok := f.domains[domain][recordID]; !ok {
+		return notFound("domain record %d not found", recordID)
+	}
+	delete(f.domains[domain], recordID)
+	return nil
+}
+
+// editRecord applies the set fields of req to record
+func editRecord(record *godo.DomainRecord, req *godo.DomainRecordEditRequest) {
+	if req.Type != "" {
+		record.Type = req.Type
+	}
+	if req.Name != "" {
+		record.Name = req.Name
+	}
+	if req.Data != "" {
+		record.Data = req.Data
+	}
+	if req.TTL != 0 {
+		record.TTL = req.TTL
+	}
+}
+
+func (f *Fake) GetFirewall(ctx context.Context, firewallID string) (*godo.Firewall, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetFirewall"); err != nil {
+		return nil, err
+	}
+	firewall, ok := f.firewalls[firewallID]
+	if !ok {
+		return nil, notFound("firewall %s not found", firewallID)
+	}
+	copied := *firewall
+	copied.DropletIDs = append([]int{}, firewall.DropletIDs...)
+	return &copied, nil
+}
+
+func (f *Fake) AddDropletsToFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("AddDropletsToFirewall"); err != nil {
+		return err
+	}
+	firewall, ok := f.firewalls[firewallID]
+	if !ok {
+		return notFound("firewall %s not found", firewallID)
+	}
+	for _, dropletID := range dropletIDs {
+		if _, ok := f.droplets[dropletID]; !ok {
+			return notFound("droplet %d not found", dropletID)
+		}
+	}
+	for _, dropletID := range dropletIDs {
+		if !containsInt(firewall.DropletIDs, dropletID) {
+			firewall.DropletIDs = append(firewall.DropletIDs, dropletID)
+		}
+	}
+	sort.Ints(firewall.DropletIDs)
+	return nil
+}
+
+func (f *Fake) RemoveDropletsFromFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("RemoveDropletsFromFirewall"); err != nil {
+		return err
+	}
+	firewall, ok := f.firewalls[firewallID]
+	if !ok {
+		return notFound("firewall %s not found", firewallID)
+	}
+	remaining := []int{}
+	for _, dropletID := range firewall.DropletIDs {
+		if !containsInt(dropletIDs, dropletID) {
+			remaining = append(remaining, dropletID)
+		}
+	}
+	firewall.DropletIDs = remaining
+	return nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
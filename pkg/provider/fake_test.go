@@ -0,0 +1,139 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestFakeFailNext(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	injected := errors.New("injected")
+	fake.FailNext("Validate", injected)
+	fake.FailNext("Validate", injected)
+
+	for i := 0; i < 2; i++ {
+		if err := fake.Validate(ctx); err != injected {
+			t.Fatalf("call %d: Validate() error = %v, want the injected error", i, err)
+		}
+	}
+	if err := fake.Validate(ctx); err != nil {
+		t.Fatalf("Validate() error = %v once the queued faults are used", err)
+	}
+	if calls := fake.Calls("Validate"); calls != 3 {
+		t.Errorf("Calls() = %d, want 3", calls)
+	}
+	// Faults only apply to the named method
+	fake.FailNext("GetDroplet", injected)
+	if err := fake.Validate(ctx); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+}
+
+func TestFakeFail(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	injected := &StatusError{StatusCode: 500, Message: "unavailable"}
+	fake.Fail("ListDroplets", injected)
+
+	for i := 0; i < 3; i++ {
+		if _, err := fake.ListDroplets(ctx); err != injected {
+			t.Fatalf("call %d: ListDroplets() error = %v, want the injected error", i, err)
+		}
+	}
+	fake.ClearFaults()
+	if _, err := fake.ListDroplets(ctx); err != nil {
+		t.Fatalf("ListDroplets() error = %v after ClearFaults", err)
+	}
+
+	// Reset removes faults and recorded calls
+	fake.Fail("ListDroplets", injected)
+	fake.Reset()
+	if _, err := fake.ListDroplets(ctx); err != nil {
+		t.Fatalf("ListDroplets() error = %v after Reset", err)
+	}
+	if calls := fake.Calls("ListDroplets"); calls != 1 {
+		t.Errorf("Calls() = %d after Reset, want 1", calls)
+	}
+}
+
+func TestFakeActionLocksFloatingIP(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	fake.ActionDuration = 50 * time.Millisecond
+	fake.AddDroplet(godo.Droplet{ID: 1})
+	fake.AddFloatingIP("1.2.3.4", 0)
+
+	action, err := fake.AssignFloatingIP(ctx, "1.2.3.4", 1)
+	if err != nil {
+		t.Fatalf("AssignFloatingIP() error = %v", err)
+	}
+	if _, err := fake.UnassignFloatingIP(ctx, "1.2.3.4"); !IsPending(err) {
+		t.Fatalf("UnassignFloatingIP() error = %v, want a 422 while the action is in progress", err)
+	}
+	if id := fake.AssignedDropletID("1.2.3.4"); id != 0 {
+		t.Errorf("AssignedDropletID() = %d before the action completed, want 0", id)
+	}
+
+	time.Sleep(2 * fake.ActionDuration)
+	completed, err := fake.GetAction(ctx, "1.2.3.4", action.ID)
+	if err != nil {
+		t.Fatalf("GetAction() error = %v", err)
+	}
+	if completed.Status != godo.ActionCompleted {
+		t.Errorf("action status = %s, want %s", completed.Status, godo.ActionCompleted)
+	}
+	if id := fake.AssignedDropletID("1.2.3.4"); id != 1 {
+		t.Errorf("AssignedDropletID() = %d, want 1", id)
+	}
+}
+
+func TestFakeCreateFloatingIPExhausted(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	seen := map[string]bool{}
+	for i := 0; i < fakeIPRangeSize; i++ {
+		floatingIP, err := fake.CreateFloatingIP(ctx, "lon1")
+		if err != nil {
+			t.Fatalf("CreateFloatingIP() %d error = %v", i, err)
+		}
+		if seen[floatingIP.IP] {
+			t.Fatalf("CreateFloatingIP() returned %s twice", floatingIP.IP)
+		}
+		seen[floatingIP.IP] = true
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := fake.CreateFloatingIP(ctx, "lon1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !IsPending(err) {
+			t.Fatalf("CreateFloatingIP() error = %v, want a 422 once the range is exhausted", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CreateFloatingIP() didn't return once the range is exhausted")
+	}
+}
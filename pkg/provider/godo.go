@@ -0,0 +1,262 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+)
+
+// GodoProvider is a FloatingIPProvider backed by the DigitalOcean API
+type GodoProvider struct {
+	Client *godo.Client
+}
+
+var _ FloatingIPProvider = &GodoProvider{}
+
+// NewGodoProvider returns a GodoProvider using a godo client built from token
+func NewGodoProvider(token string) FloatingIPProvider {
+	return &GodoProvider{Client: godo.NewFromToken(token)}
+}
+
+// GodoOptions configures the godo client of GodoProviders
+type GodoOptions struct {
+	// APIURL is the base URL of the API. Empty uses the DigitalOcean API.
+	APIURL string
+	// QPS limits the requests per second made by each provider. 0 disables the limit.
+	QPS float32
+	// Burst is the number of requests which may exceed QPS at once
+	Burst int
+}
+
+// NewGodoProviderFunc returns a function building GodoProviders configured by opts
+func NewGodoProviderFunc(opts GodoOptions) (func(token string) FloatingIPProvider, error) {
+	if opts.APIURL == "" && opts.QPS == 0 {
+		return NewGodoProvider, nil
+	}
+	var baseURL *url.URL
+	if opts.APIURL != "" {
+		// godo resolves request paths relative to the base URL
+		apiURL := opts.APIURL
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		var err error
+		baseURL, err = url.Parse(apiURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func(token string) FloatingIPProvider {
+		var client *godo.Client
+		if opts.QPS > 0 {
+			burst := opts.Burst
+			if burst < 1 {
+				burst = 1
+			}
+			// Mirror godo.NewFromToken with the limiter in front of the oauth2 transport
+			cleanToken := strings.Trim(strings.TrimSpace(token), "'")
+			httpClient := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: cleanToken}))
+			httpClient.Transport = &rateLimitedTransport{
+				base:    httpClient.Transport,
+				limiter: rate.NewLimiter(rate.Limit(opts.QPS), burst),
+			}
+			client = godo.NewClient(httpClient)
+		} else {
+			client = godo.NewFromToken(token)
+		}
+		if baseURL != nil {
+			client.BaseURL = baseURL
+		}
+		return &GodoProvider{Client: client}
+	}, nil
+}
+
+// rateLimitedTransport waits for the limiter before each request
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// convertError converts godo errors into a StatusError
+func convertError(err error) error {
+	doError, ok := err.(*godo.ErrorResponse)
+	if !ok || doError.Response == nil {
+		return err
+	}
+	return &StatusError{StatusCode: doError.Response.StatusCode, Message: doError.Message}
+}
+
+func (p *GodoProvider) Validate(ctx context.Context) error {
+	_, _, err := p.Client.Account.Get(ctx)
+	return convertError(err)
+}
+
+func (p *GodoProvider) GetFloatingIP(ctx context.Context, ip string) (*godo.FloatingIP, error) {
+	floatingIP, _, err := p.Client.FloatingIPs.Get(ctx, ip)
+	return floatingIP, convertError(err)
+}
+
+func (p *GodoProvider) ListFloatingIPs(ctx context.Context) ([]godo.FloatingIP, error) {
+	var floatingIPs []godo.FloatingIP
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		page, resp, err := p.Client.FloatingIPs.List(ctx, opt)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		floatingIPs = append(floatingIPs, page...)
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return floatingIPs, nil
+		}
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = current + 1
+	}
+}
+
+func (p *GodoProvider) CreateFloatingIP(ctx context.Context, region string) (*godo.FloatingIP, error) {
+	floatingIP, _, err := p.Client.FloatingIPs.Create(ctx, &godo.FloatingIPCreateRequest{Region: region})
+	return floatingIP, convertError(err)
+}
+
+func (p *GodoProvider) AssignFloatingIP(ctx context.Context, ip string, dropletID int) (*godo.Action, error) {
+	action, _, err := p.Client.FloatingIPActions.Assign(ctx, ip, dropletID)
+	return action, convertError(err)
+}
+
+func (p *GodoProvider) UnassignFloatingIP(ctx context.Context, ip string) (*godo.Action, error) {
+	action, _, err := p.Client.FloatingIPActions.Unassign(ctx, ip)
+	return action, convertError(err)
+}
+
+func (p *GodoProvider) GetAction(ctx context.Context, ip string, actionID int) (*godo.Action, error) {
+	action, _, err := p.Client.FloatingIPActions.Get(ctx, ip, actionID)
+	return action, convertError(err)
+}
+
+func (p *GodoProvider) GetDroplet(ctx context.Context, dropletID int) (*godo.Droplet, error) {
+	droplet, _, err := p.Client.Droplets.Get(ctx, dropletID)
+	return droplet, convertError(err)
+}
+
+func (p *GodoProvider) ListDroplets(ctx context.Context) ([]godo.Droplet, error) {
+	return listDroplets(func(opt *godo.ListOptions) ([]godo.Droplet, *godo.Response, error) {
+		return p.Client.Droplets.List(ctx, opt)
+	})
+}
+
+func (p *GodoProvider) ListDropletsByTag(ctx context.Context, tag string) ([]godo.Droplet, error) {
+	return listDroplets(func(opt *godo.ListOptions) ([]godo.Droplet, *godo.Response, error) {
+		return p.Client.Droplets.ListByTag(ctx, tag, opt)
+	})
+}
+
+// listDroplets collects every page of droplets returned by list
+func listDroplets(list func(opt *godo.ListOptions) ([]godo.Droplet, *godo.Response, error)) ([]godo.Droplet, error) {
+	var droplets []godo.Droplet
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		page, resp, err := list(opt)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		droplets = append(droplets, page...)
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return droplets, nil
+		}
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = current + 1
+	}
+}
+
+func (p *GodoProvider) ListDomainRecords(ctx context.Context, domain string) ([]godo.DomainRecord, error) {
+	var records []godo.DomainRecord
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		page, resp, err := p.Client.Domains.Records(ctx, domain, opt)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		records = append(records, page...)
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return records, nil
+		}
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = current + 1
+	}
+}
+
+func (p *GodoProvider) CreateDomainRecord(
+	ctx context.Context,
+	domain string,
+	req *godo.DomainRecordEditRequest,
+) (*godo.DomainRecord, error) {
+	record, _, err := p.Client.Domains.CreateRecord(ctx, domain, req)
+	return record, convertError(err)
+}
+
+func (p *GodoProvider) EditDomainRecord(
+	ctx context.Context,
+	domain string,
+	recordID int,
+	req *godo.DomainRecordEditRequest,
+) (*godo.DomainRecord, error) {
+	record, _, err := p.Client.Domains.EditRecord(ctx, domain, recordID, req)
+	return record, convertError(err)
+}
+
+func (p *GodoProvider) DeleteDomainRecord(ctx context.Context, domain string, recordID int) error {
+	_, err := p.Client.Domains.DeleteRecord(ctx, domain, recordID)
+	return convertError(err)
+}
+
+func (p *GodoProvider) GetFirewall(ctx context.Context, firewallID string) (*godo.Firewall, error) {
+	firewall, _, err := p.Client.Firewalls.Get(ctx, firewallID)
+	return firewall, convertError(err)
+}
+
+func (p *GodoProvider) AddDropletsToFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error {
+	_, err := p.Client.Firewalls.AddDroplets(ctx, firewallID, dropletIDs...)
+	return convertError(err)
+}
+
+func (p *GodoProvider) RemoveDropletsFromFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error {
+	_, err := p.Client.Firewalls.RemoveDroplets(ctx, firewallID, dropletIDs...)
+	return convertError(err)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"context"
+
+	"github.com/digitalocean/godo"
+)
+
+// Observed is a FloatingIPProvider which reports the outcome of every call to Observe
+type Observed struct {
+	FloatingIPProvider
+	Observe func(err error)
+}
+
+var _ FloatingIPProvider = &Observed{}
+
+// WithObserver wraps p so that observe is called with the error of every API call
+func WithObserver(p FloatingIPProvider, observe func(err error)) FloatingIPProvider {
+	return &Observed{FloatingIPProvider: p, Observe: observe}
+}
+
+func (p *Observed) observe(err error) error {
+	p.Observe(err)
+	return err
+}
+
+func (p *Observed) Validate(ctx context.Context) error {
+	return p.observe(p.FloatingIPProvider.Validate(ctx))
+}
+
+func (p *Observed) GetFloatingIP(ctx context.Context, ip string) (*godo.FloatingIP, error) {
+	floatingIP, err := p.FloatingIPProvider.GetFloatingIP(ctx, ip)
+	return floatingIP, p.observe(err)
+}
+
+func (p *Observed) ListFloatingIPs(ctx context.Context) ([]godo.FloatingIP, error) {
+	floatingIPs, err := p.FloatingIPProvider.ListFloatingIPs(ctx)
+	return floatingIPs, p.observe(err)
+}
+
+func (p *Observed) CreateFloatingIP(ctx context.Context, region string) (*godo.FloatingIP, error) {
+	floatingIP, err := p.FloatingIPProvider.CreateFloatingIP(ctx, region)
+	return floatingIP, p.observe(err)
+}
+
+func (p *Observed) AssignFloatingIP(ctx context.Context, ip string, dropletID int) (*godo.Action, error) {
+	action, err := p.FloatingIPProvider.AssignFloatingIP(ctx, ip, dropletID)
+	return action, p.observe(err)
+}
+
+func (p *Observed) UnassignFloatingIP(ctx context.Context, ip string) (*godo.Action, error) {
+	action, err := p.FloatingIPProvider.UnassignFloatingIP(ctx, ip)
+	return action, p.observe(err)
+}
+
+func (p *Observed) GetAction(ctx context.Context, ip string, actionID int) (*godo.Action, error) {
+	action, err := p.FloatingIPProvider.GetAction(ctx, ip, actionID)
+	return action, p.observe(err)
+}
+
+func (p *Observed) GetDroplet(ctx context.Context, dropletID int) (*godo.Droplet, error) {
+	droplet, err := p.FloatingIPProvider.GetDroplet(ctx, dropletID)
+	return droplet, p.observe(err)
+}
+
+func (p *Observed) ListDroplets(ctx context.Context) ([]godo.Droplet, error) {
+	droplets, err := p.FloatingIPProvider.ListDroplets(ctx)
+	return droplets, p.observe(err)
+}
+
+func (p *Observed) ListDropletsByTag(ctx context.Context, tag string) ([]godo.Droplet, error) {
+	droplets, err := p.FloatingIPProvider.ListDropletsByTag(ctx, tag)
+	return droplets, p.observe(err)
+}
+
+func (p *Observed) ListDomainRecords(ctx context.Context, domain string) ([]godo.DomainRecord, error) {
+	records, err := p.FloatingIPProvider.ListDomainRecords(ctx, domain)
+	return records, p.observe(err)
+}
+
+func (p *Observed) CreateDomainRecord(
+	ctx context.Context,
+	domain string,
+	req *godo.DomainRecordEditRequest,
+) (*godo.DomainRecord, error) {
+	record, err := p.FloatingIPProvider.CreateDomainRecord(ctx, domain, req)
+	return record, p.observe(err)
+}
+
+func (p *Observed) EditDomainRecord(
+	ctx context.Context,
+	domain string,
+	recordID int,
+	req *godo.DomainRecordEditRequest,
+) (*godo.DomainRecord, error) {
+	record, err := p.FloatingIPProvider.EditDomainRecord(ctx, domain, recordID, req)
+	return record, p.observe(err)
+}
+
+func (p *Observed) DeleteDomainRecord(ctx context.Context, domain string, recordID int) error {
+	return p.observe(p.FloatingIPProvider.DeleteDomainRecord(ctx, domain, recordID))
+}
+
+func (p *Observed) GetFirewall(ctx context.Context, firewallID string) (*godo.Firewall, error) {
+	firewall, err := p.FloatingIPProvider.GetFirewall(ctx, firewallID)
+	return firewall, p.observe(err)
+}
+
+func (p *Observed) AddDropletsToFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error {
+	return p.observe(p.FloatingIPProvider.AddDropletsToFirewall(ctx, firewallID, dropletIDs...))
+}
+
+func (p *Observed) RemoveDropletsFromFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error {
+	return p.observe(p.FloatingIPProvider.RemoveDropletsFromFirewall(ctx, firewallID, dropletIDs...))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestObserved(t *testing.T) {
+	ctx := context.Background()
+	calls := map[string]func(p FloatingIPProvider) error{
+		"Validate": func(p FloatingIPProvider) error {
+			return p.Validate(ctx)
+		},
+		"GetFloatingIP": func(p FloatingIPProvider) error {
+			_, err := p.GetFloatingIP(ctx, "203.0.113.1")
+			return err
+		},
+		"ListFloatingIPs": func(p FloatingIPProvider) error {
+			_, err := p.ListFloatingIPs(ctx)
+			return err
+		},
+		"CreateFloatingIP": func(p FloatingIPProvider) error {
+			_, err := p.CreateFloatingIP(ctx, "lon1")
+			return err
+		},
+		"AssignFloatingIP": func(p FloatingIPProvider) error {
+			_, err := p.AssignFloatingIP(ctx, "203.0.113.1", 1)
+			return err
+		},
+		"UnassignFloatingIP": func(p FloatingIPProvider) error {
+			_, err := p.UnassignFloatingIP(ctx, "203.0.113.1")
+			return err
+		},
+		"GetAction": func(p FloatingIPProvider) error {
+			_, err := p.GetAction(ctx, "203.0.113.1", 1)
+			return err
+		},
+		"GetDroplet": func(p FloatingIPProvider) error {
+			_, err := p.GetDroplet(ctx, 1)
+			return err
+		},
+		"ListDroplets": func(p FloatingIPProvider) error {
+			_, err := p.ListDroplets(ctx)
+			return err
+		},
+		"ListDropletsByTag": func(p FloatingIPProvider) error {
+			_, err := p.ListDropletsByTag(ctx, "edge")
+			return err
+		},
+		"ListDomainRecords": func(p FloatingIPProvider) error {
+			_, err := p.ListDomainRecords(ctx, "example.com")
+			return err
+		},
+		"CreateDomainRecord": func(p FloatingIPProvider) error {
+			_, err := p.CreateDomainRecord(ctx, "example.com", &godo.DomainRecordEditRequest{Type: "A", Name: "www"})
+			return err
+		},
+		"EditDomainRecord": func(p FloatingIPProvider) error {
+			_, err := p.EditDomainRecord(ctx, "example.com", 1, &godo.DomainRecordEditRequest{Type: "A", Name: "www"})
+			return err
+		},
+		"DeleteDomainRecord": func(p FloatingIPProvider) error {
+			return p.DeleteDomainRecord(ctx, "example.com", 1)
+		},
+		"GetFirewall": func(p FloatingIPProvider) error {
+			_, err := p.GetFirewall(ctx, "fw")
+			return err
+		},
+		"AddDropletsToFirewall": func(p FloatingIPProvider) error {
+			return p.AddDropletsToFirewall(ctx, "fw", 1)
+		},
+		"RemoveDropletsFromFirewall": func(p FloatingIPProvider) error {
+			return p.RemoveDropletsFromFirewall(ctx, "fw", 1)
+		},
+	}
+
+	for method, call := range calls {
+		t.Run(method, func(t *testing.T) {
+			fake := NewFake()
+			var observed []error
+			p := WithObserver(fake, func(err error) { observed = append(observed, err) })
+
+			injected := errors.New("injected " + method)
+			fake.FailNext(method, injected)
+			if err := call(p); !errors.Is(err, injected) {
+				t.Errorf("%s() error = %v, want the injected error", method, err)
+			}
+			if len(observed) != 1 || !errors.Is(observed[0], injected) {
+				t.Errorf("observed = %v, want the injected error", observed)
+			}
+			if calls := fake.Calls(method); calls != 1 {
+				t.Errorf("%s called %d times, want 1", method, calls)
+			}
+		})
+	}
+}
+
+func TestObservedSuccess(t *testing.T) {
+	fake := NewFake()
+	fake.AddFloatingIP("203.0.113.1", 0)
+	var observed []error
+	p := WithObserver(fake, func(err error) { observed = append(observed, err) })
+
+	floatingIP, err := p.GetFloatingIP(context.Background(), "203.0.113.1")
+	if err != nil {
+		t.Fatalf("GetFloatingIP() error = %v", err)
+	}
+	if floatingIP.IP != "203.0.113.1" {
+		t.Errorf("GetFloatingIP() = %s, want 203.0.113.1", floatingIP.IP)
+	}
+	if len(observed) != 1 || observed[0] != nil {
+		t.Errorf("observed = %v, want one successful call", observed)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 Alex Williams.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package provider abstracts the DigitalOcean API used by the controllers
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/digitalocean/godo"
+)
+
+// FloatingIPProvider manages floating IPs and looks up droplets
+type FloatingIPProvider interface {
+	// Validate checks that the provider's credentials are accepted
+	Validate(ctx context.Context) error
+	// GetFloatingIP returns a floating IP by address
+	GetFloatingIP(ctx context.Context, ip string) (*godo.FloatingIP, error)
+	// ListFloatingIPs returns every floating IP in the account
+	ListFloatingIPs(ctx context.Context) ([]godo.FloatingIP, error)
+	// CreateFloatingIP reserves a new floating IP in a region
+	CreateFloatingIP(ctx context.Context, region string) (*godo.FloatingIP, error)
+	// AssignFloatingIP starts an action assigning a floating IP to a droplet
+	AssignFloatingIP(ctx context.Context, ip string, dropletID int) (*godo.Action, error)
+	// UnassignFloatingIP starts an action unassigning a floating IP from its droplet
+	UnassignFloatingIP(ctx context.Context, ip string) (*godo.Action, error)
+	// GetAction returns the status of an action on a floating IP
+	GetAction(ctx context.Context, ip string, actionID int) (*godo.Action, error)
+	// GetDroplet returns a droplet by ID
+	GetDroplet(ctx context.Context, dropletID int) (*godo.Droplet, error)
+	// ListDroplets returns every droplet in the account
+	ListDroplets(ctx context.Context) ([]godo.Droplet, error)
+	// ListDropletsByTag returns every droplet with a tag
+	ListDropletsByTag(ctx context.Context, tag string) ([]godo.Droplet, error)
+	// ListDomainRecords returns every record of a domain
+	ListDomainRecords(ctx context.Context, domain string) ([]godo.DomainRecord, error)
+	// CreateDomainRecord creates a record in a domain
+	CreateDomainRecord(ctx context.Context, domain string, req *godo.DomainRecordEditRequest) (*godo.DomainRecord, error)
+	// EditDomainRecord updates a record in a domain
+	EditDomainRecord(ctx context.Context, domain string, recordID int, req *godo.DomainRecordEditRequest) (*godo.DomainRecord, error)
+	// DeleteDomainRecord deletes a record from a domain
+	DeleteDomainRecord(ctx context.Context, domain string, recordID int) error
+	// GetFirewall returns a Cloud Firewall by ID
+	GetFirewall(ctx context.Context, firewallID string) (*godo.Firewall, error)
+	// AddDropletsToFirewall adds droplets to a Cloud Firewall
+	AddDropletsToFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error
+	// RemoveDropletsFromFirewall removes droplets from a Cloud Firewall
+	RemoveDropletsFromFirewall(ctx context.Context, firewallID string, dropletIDs ...int) error
+}
+
+// StatusError is returned when the API responds with an error status code
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+}
+
+// HasStatus returns true when err is a StatusError with the given status code
+func HasStatus(err error, statusCode int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
+
+// IsPending returns true when the floating IP has an action in progress
+func IsPending(err error) bool {
+	return HasStatus(err, http.StatusUnprocessableEntity)
+}
+
+// IsNotFound returns true when the requested resource doesn't exist
+func IsNotFound(err error) bool {
+	return HasStatus(err, http.StatusNotFound)
+}
+
+// AnchorIPv4 returns the anchor network of a droplet. The anchor IP is the private
+// address on the droplet's public interface which floating IP traffic is routed through.
+func AnchorIPv4(droplet *godo.Droplet) (*godo.NetworkV4, bool) {
+	if droplet.Networks == nil {
+		return nil, false
+	}
+	for i := range droplet.Networks.V4 {
+		network := &droplet.Networks.V4[i]
+		if network.Type != "public" {
+			continue
+		}
+		if ip := net.ParseIP(network.IPAddress); ip != nil && ip.IsPrivate() {
+			return network, true
+		}
+	}
+	return nil, false
+}